@@ -0,0 +1,402 @@
+package decap
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+// The built-in actions are registered like any other, see RegisterAction.
+func init() {
+	RegisterAction("click", specClick, buildClick)
+	RegisterAction("click_at", specClickAt, buildClickAt)
+	RegisterAction("context_click", specContextClick, buildContextClick)
+	RegisterAction("dblclick", specDblclick, buildDblclick)
+	RegisterAction("drag", specDrag, buildDrag)
+	RegisterAction("eval", specEval, buildEval)
+	RegisterAction("hide_nav_buttons", specHideNavButtons, buildHideNavButtons)
+	RegisterAction("hover", specHover, buildHover)
+	RegisterAction("listen", specListen, buildListen)
+	RegisterAction("load_html", specLoadHTML, buildLoadHTML)
+	RegisterAction("load_tab", specLoadTab, buildLoadTab)
+	RegisterAction("navigate", specNavigate, buildNavigate)
+	RegisterAction("outer_html", specOuterHTML, buildOuterHTML)
+	RegisterAction("print_to_pdf", specPrintToPDF, buildPrintToPDF)
+	RegisterAction("remove", specRemove, buildRemove)
+	RegisterAction("remove_info_boxes", specRemoveInfoBoxes, buildRemoveInfoBoxes)
+	RegisterAction("remove_info_sections", specRemoveInfoSections, buildRemoveInfoSections)
+	RegisterAction("remove_nav_sections", specRemoveNavSections, buildRemoveNavSections)
+	RegisterAction("screenshot", specScreenshot, buildScreenshot)
+	RegisterAction("scroll", specScroll, buildScroll)
+	RegisterAction("scroll_until_stable", specScrollUntilStable, buildScrollUntilStable)
+	RegisterAction("sleep", specSleep, buildSleep)
+	RegisterAction("wait_for", specWaitFor, buildWaitFor)
+	RegisterCondition("element_exists", specElementExists, buildElementExists)
+	RegisterCondition("element_visible", specElementVisible, buildElementVisible)
+}
+
+var (
+	selectorArg = ArgSpec{Name: "selector", Type: ArgSelector}
+
+	lifecycleEvents = []string{
+		"DOMContentLoaded",
+		"firstContentfulPaint",
+		"firstImagePaint",
+		"firstMeaningfulPaint",
+		"firstMeaningfulPaintCandidate",
+		"firstPaint",
+		"init",
+		"load",
+		"networkAlmostIdle",
+		"networkIdle",
+		networkIdleEvent,
+	}
+)
+
+var specClick = ActionSpec{
+	Description: "Click the first element matching the selector.",
+	Args:        []ArgSpec{selectorArg},
+}
+
+func buildClick(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return click(xa.Arg(1)), nil
+}
+
+var specClickAt = ActionSpec{
+	Description: "Click at viewport coordinates.",
+	Args: []ArgSpec{
+		{Name: "x", Type: ArgNumber},
+		{Name: "y", Type: ArgNumber},
+	},
+}
+
+func buildClickAt(s *ActionScope, xa Action) (chromedp.Action, error) {
+	// the args have been checked against the spec
+	x, _ := strconv.ParseFloat(xa.Arg(1), 64)
+	y, _ := strconv.ParseFloat(xa.Arg(2), 64)
+	return mouseClick(mouseTarget{x: x, y: y}, input.Left, 1), nil
+}
+
+var specContextClick = ActionSpec{
+	Description: "Right-click the center of the element.",
+	Args:        []ArgSpec{selectorArg},
+}
+
+func buildContextClick(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return mouseClick(mouseTarget{sel: xa.Arg(1)}, input.Right, 1), nil
+}
+
+var specDblclick = ActionSpec{
+	Description: "Double-click the center of the element.",
+	Args:        []ArgSpec{selectorArg},
+}
+
+func buildDblclick(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return mouseClick(mouseTarget{sel: xa.Arg(1)}, input.Left, 2), nil
+}
+
+var specDrag = ActionSpec{
+	Description: "Drag the mouse from one element or point to another.",
+	Args: []ArgSpec{
+		{Name: "from", Type: ArgTarget},
+		{Name: "to", Type: ArgTarget},
+	},
+}
+
+func buildDrag(s *ActionScope, xa Action) (chromedp.Action, error) {
+	// the args have been checked against the spec
+	from, _ := parseMouseTarget(xa.Arg(1))
+	to, _ := parseMouseTarget(xa.Arg(2))
+	return drag(from, to), nil
+}
+
+var specEval = ActionSpec{
+	Description: "Evaluate JavaScript, adding its result to the block output.",
+	Args:        []ArgSpec{{Name: "expression", Type: ArgJavaScript}},
+}
+
+func buildEval(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return evaluate(xa.Arg(1), s.Out()), nil
+}
+
+var specHideNavButtons = ActionSpec{
+	Description: "Hide navigation buttons such as chat widgets.",
+}
+
+func buildHideNavButtons(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return hideElements(navButtonSelector), nil
+}
+
+var specHover = ActionSpec{
+	Description: "Move the mouse over the center of the element.",
+	Args:        []ArgSpec{selectorArg},
+}
+
+func buildHover(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return hover(mouseTarget{sel: xa.Arg(1)}), nil
+}
+
+var specListen = ActionSpec{
+	Description: "Wait for lifecycle events of the page (by default the pageload events).",
+	Args: []ArgSpec{{
+		Name:     "event",
+		Type:     ArgString,
+		Enum:     lifecycleEvents,
+		Optional: true,
+	}},
+	Variadic: true,
+}
+
+func buildListen(s *ActionScope, xa Action) (chromedp.Action, error) {
+	events := xa.Args()
+	if len(events) == 0 {
+		events = defaultPageloadEvents()
+	}
+	idle := waitNetworkIdle(&s.r.netTracker, s.r.networkIdle, DefaultWaitInterval)
+	return listen(s.r, idle, events...), nil
+}
+
+var specLoadHTML = ActionSpec{
+	Description: "Load an HTML document into the tab.",
+	Args:        []ArgSpec{{Name: "html", Type: ArgHTML}},
+}
+
+func buildLoadHTML(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return loadHTML(xa.Arg(1)), nil
+}
+
+var specLoadTab = ActionSpec{
+	Description: "Continue in a tab kept by reuse_tab. Must be the first action of the first block.",
+	Args:        []ArgSpec{{Name: "tab_id", Type: ArgString}},
+}
+
+func buildLoadTab(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return nil, fmt.Errorf("load_tab must be the first action of the first action block")
+}
+
+var specNavigate = ActionSpec{
+	Description: "Navigate to the URL.",
+	Args:        []ArgSpec{{Name: "url", Type: ArgURL}},
+}
+
+func buildNavigate(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return navigate(xa.Arg(1)), nil
+}
+
+var specOuterHTML = ActionSpec{
+	Description: "Add the outer HTML of the document to the block output.",
+}
+
+func buildOuterHTML(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return outerHTML(s.Out()), nil
+}
+
+var specPrintToPDF = ActionSpec{
+	Description: "Print the page to a PDF artifact. The margins (top, right, bottom, " +
+		"left) may also be given as four positional numbers.",
+	Named: []ArgSpec{
+		{Name: "margin_top", Type: ArgNumber, Optional: true},
+		{Name: "margin_right", Type: ArgNumber, Optional: true},
+		{Name: "margin_bottom", Type: ArgNumber, Optional: true},
+		{Name: "margin_left", Type: ArgNumber, Optional: true},
+		{Name: "paper", Type: ArgString, Enum: slices.Sorted(maps.Keys(paperSizes)), Optional: true},
+		{Name: "paper_width", Type: ArgNumber, Optional: true, Description: "inches"},
+		{Name: "paper_height", Type: ArgNumber, Optional: true, Description: "inches"},
+		{Name: "landscape", Type: ArgBoolean, Optional: true},
+		{Name: "scale", Type: ArgNumber, Optional: true, Description: "between 0.1 and 2"},
+		{Name: "print_background", Type: ArgBoolean, Optional: true},
+		{Name: "page_ranges", Type: ArgString, Optional: true, Description: `e.g. "1-5, 8, 11-13"`},
+		{Name: "prefer_css_page_size", Type: ArgBoolean, Optional: true},
+		{Name: "display_header_footer", Type: ArgBoolean, Optional: true},
+		{Name: "header_template", Type: ArgHTML, Optional: true},
+		{Name: "footer_template", Type: ArgHTML, Optional: true},
+		{Name: "name", Type: ArgString, Optional: true, Description: "artifact name"},
+	},
+}
+
+func buildPrintToPDF(s *ActionScope, xa Action) (chromedp.Action, error) {
+	opts, err := parsePDFOptions(s, xa)
+	if err != nil {
+		return nil, err
+	}
+	if opts.name, err = s.ArtifactName(opts.name, "pdf"); err != nil {
+		return nil, fmt.Errorf("print_to_pdf: %s", err)
+	}
+	return printToPDF(opts, &s.r.res), nil
+}
+
+var specRemove = ActionSpec{
+	Description: "Remove all elements matching the selectors.",
+	Args:        []ArgSpec{selectorArg},
+	Variadic:    true,
+}
+
+func buildRemove(s *ActionScope, xa Action) (chromedp.Action, error) {
+	for i, sel := range xa.Args() {
+		if strings.Contains(sel, "'") {
+			return nil, fmt.Errorf(`remove[%d]: selector contains "'"`, i)
+		}
+	}
+	return removeElements(strings.Join(xa.Args(), ", ")), nil
+}
+
+var specRemoveInfoBoxes = ActionSpec{
+	Description: "Remove cookie banners, consent dialogs and similar overlays.",
+}
+
+func buildRemoveInfoBoxes(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return removeElements(infoBoxSelector), nil
+}
+
+var specRemoveInfoSections = ActionSpec{
+	Description: "Remove informational page sections.",
+}
+
+func buildRemoveInfoSections(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return removeElements(infoSectionSelector), nil
+}
+
+var specRemoveNavSections = ActionSpec{
+	Description: "Remove navigation sections such as headers and menus.",
+}
+
+func buildRemoveNavSections(s *ActionScope, xa Action) (chromedp.Action, error) {
+	return removeElements(navSectionSelector), nil
+}
+
+var specScreenshot = ActionSpec{
+	Description: "Capture a screenshot artifact of the page, an element or a clip.",
+	Named: []ArgSpec{
+		{Name: "element", Type: ArgSelector, Optional: true},
+		{Name: "padding", Type: ArgString, Optional: true, Description: "CSS padding around element"},
+		{Name: "format", Type: ArgString, Enum: []string{"jpeg", "jpg", "png", "webp"}, Optional: true},
+		{Name: "quality", Type: ArgInteger, Optional: true, Description: "between 0 and 100 (jpeg and webp)"},
+		{Name: "clip", Type: ArgString, Optional: true, Description: `"x,y,width,height"`},
+		{Name: "full_page", Type: ArgBoolean, Optional: true},
+		{Name: "scale", Type: ArgNumber, Optional: true},
+		{Name: "omit_background", Type: ArgBoolean, Optional: true},
+		{Name: "name", Type: ArgString, Optional: true, Description: "artifact name"},
+	},
+}
+
+func buildScreenshot(s *ActionScope, xa Action) (chromedp.Action, error) {
+	args, err := s.NamedArgs(xa)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseScreenshotOptions(args)
+	if err != nil {
+		return nil, fmt.Errorf("screenshot: %s", err)
+	}
+	if opts.name, err = s.ArtifactName(opts.name, "screenshot"); err != nil {
+		return nil, fmt.Errorf("screenshot: %s", err)
+	}
+	return screenshot(opts, &s.r.res), nil
+}
+
+var specScroll = ActionSpec{
+	Description: "Scroll the element into view, or to the bottom of the page.",
+	Args:        []ArgSpec{{Name: "selector", Type: ArgSelector, Optional: true}},
+}
+
+func buildScroll(s *ActionScope, xa Action) (chromedp.Action, error) {
+	if len(xa.Args()) == 0 {
+		return scrollToBottom(), nil
+	}
+	return chromedp.ScrollIntoView(xa.Arg(1), chromedp.ByQuery), nil
+}
+
+var specScrollUntilStable = ActionSpec{
+	Description: "Scroll down until the page height stops growing, then back to the top.",
+	Named: []ArgSpec{
+		{Name: "interval", Type: ArgDuration, Optional: true},
+		{Name: "stable", Type: ArgDuration, Optional: true},
+		{Name: "step", Type: ArgInteger, Optional: true, Description: "pixels"},
+		{Name: "max_height", Type: ArgInteger, Optional: true, Description: "pixels"},
+		{Name: "max_iterations", Type: ArgInteger, Optional: true},
+	},
+}
+
+func buildScrollUntilStable(s *ActionScope, xa Action) (chromedp.Action, error) {
+	args, err := s.NamedArgs(xa)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseScrollOptions(args)
+	if err != nil {
+		return nil, fmt.Errorf("scroll_until_stable: %s", err)
+	}
+	return scrollUntilStable(opts), nil
+}
+
+var specSleep = ActionSpec{
+	Description: "Sleep for the duration, or for global_render_delay.",
+	Args:        []ArgSpec{{Name: "duration", Type: ArgDuration, Optional: true}},
+}
+
+func buildSleep(s *ActionScope, xa Action) (chromedp.Action, error) {
+	if len(xa.Args()) == 0 {
+		return chromedp.Sleep(s.RenderDelay()), nil
+	}
+	delay, _ := time.ParseDuration(xa.Arg(1)) // checked against the spec
+	return chromedp.Sleep(delay), nil
+}
+
+var specWaitFor = ActionSpec{
+	Description: "Poll until the condition holds, failing after the timeout.",
+	Args: []ArgSpec{
+		{
+			Name: "condition",
+			Type: ArgString,
+			Enum: []string{"dom_stable", "exists", "gone", "js", "network_idle",
+				"request", "url", "visible"},
+		},
+		{
+			Name: "argument",
+			Type: ArgString,
+			Description: "a selector (exists, gone, visible), JavaScript (js), " +
+				"regexp (request, url) or quiet duration (dom_stable, network_idle)",
+		},
+	},
+	Named: []ArgSpec{
+		{Name: "timeout", Type: ArgDuration, Optional: true},
+		{Name: "interval", Type: ArgDuration, Optional: true},
+		{Name: "max_inflight", Type: ArgInteger, Optional: true, Description: "network_idle only"},
+		{Name: "ignore", Type: ArgRegexp, Optional: true, Description: "network_idle only"},
+	},
+}
+
+func buildWaitFor(s *ActionScope, xa Action) (chromedp.Action, error) {
+	args, err := s.NamedArgs(xa)
+	if err != nil {
+		return nil, err
+	}
+	return s.r.parseWaitFor(xa, args, xa.NamedArgValues(len(specWaitFor.Args)+1, "ignore"))
+}
+
+var specElementExists = ActionSpec{
+	Description: "Repeat the block while an element matches the selector.",
+	Args:        []ArgSpec{selectorArg},
+}
+
+func buildElementExists(s *ActionScope, xa Action, res *bool) (chromedp.Action, error) {
+	return elementExists(xa.Arg(1), res), nil
+}
+
+var specElementVisible = ActionSpec{
+	Description: "Repeat the block while the element is visible.",
+	Args:        []ArgSpec{selectorArg},
+}
+
+func buildElementVisible(s *ActionScope, xa Action, res *bool) (chromedp.Action, error) {
+	if strings.Contains(xa.Arg(1), "'") {
+		return nil, fmt.Errorf(`element_visible selector contains "'"`)
+	}
+	return elementVisible(xa.Arg(1), res), nil
+}
@@ -3,33 +3,30 @@ package decap
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"math"
 	"math/rand"
-	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/input"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
 )
 
 var (
-	debugMode    bool
-	scrollCmd    string
-	tabLoadQuery = make(chan string)
-	tabLoadReply = make(chan session)
-	tabSave      = make(chan session)
-	windowClose  = make(chan string)
-	windowQuery  = make(chan session)
-	windowReply  = make(chan session)
-	tabRegexp    = regexp.MustCompile(`^([[:xdigit:]]{8,})_([[:xdigit:]]{8})$`)
+	scrollCmd   string
+	tabRegexp   = regexp.MustCompile(`^([[:xdigit:]]{8,})_([[:xdigit:]]{8})$`)
+	pointRegexp = regexp.MustCompile(`^\s*(-?[0-9.]+)\s*,\s*(-?[0-9.]+)\s*$`)
 )
 
 func init() {
-	debugMode = os.Getenv("DEBUG") == "true"
-
 	const cmdFmt = `%s.style.overflow = ""; %[1]s.scrollTo(0,document.body.scrollHeight);`
 	tryScrollBody := fmt.Sprintf(cmdFmt, "document.body")
 	tryScrollHTML := fmt.Sprintf(cmdFmt, "document.documentElement")
@@ -47,86 +44,8 @@ type session struct {
 	id      string
 	last    time.Time
 	timeout time.Duration
-}
-
-func loadWindow(id string, timeout time.Duration) session {
-	windowQuery <- session{id: id, timeout: timeout}
-	return <-windowReply
-}
-
-func closeWindow(id string) {
-	windowClose <- id
-}
-
-func loadTab(id string) session {
-	tabLoadQuery <- id
-	return <-tabLoadReply
-}
-
-func (ses session) saveTab() {
-	tabSave <- ses
-}
-
-func AllocateSessions() {
-	GCInterval := time.NewTicker(2 * time.Second)
-	rand.Seed(time.Now().UnixNano())
-
-	windows := make(map[string]session)
-	tabs := make(map[string]session)
-
-	for {
-		select {
-		case q := <-windowQuery:
-			w, ok := windows[q.id]
-			if !ok {
-				w = createWindow(q.id)
-				w.timeout = 30 * time.Second
-			}
-			if q.timeout > w.timeout {
-				w.timeout = q.timeout
-			}
-			w.last = time.Now()
-			windowReply <- w
-			windows[w.id] = w
-
-		case id := <-windowClose:
-			if w, ok := windows[id]; ok {
-				w.shutdown()
-				delete(windows, id)
-			}
-
-		case t := <-tabSave:
-			tabs[t.id] = t
-
-		case id := <-tabLoadQuery:
-			tabLoadReply <- tabs[id]
-			delete(tabs, id)
-
-			prefix, _, err := parseTabID(id)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Tab ID parse error: %s\n", err)
-				break
-			}
-			if w, ok := windows[prefix]; ok {
-				w.last = time.Now()
-				windows[prefix] = w
-			} else {
-				fmt.Fprintf(os.Stderr, "Tab ID (%s) didn't match any window\n", id)
-			}
-
-		case <-GCInterval.C:
-			for _, w := range windows {
-				if elapsed := time.Since(w.last); elapsed > w.timeout {
-					fmt.Fprintf(os.Stderr,
-						"Window (session %s) was last requested %.1f seconds ago, closing it\n",
-						w.id, elapsed.Seconds())
-					w.shutdown()
-					msg := removeWindow(w.id, &windows, &tabs)
-					fmt.Fprintln(os.Stderr, msg)
-				}
-			}
-		}
-	}
+	launch  time.Duration // time taken to create the window, only set in replies
+	pins    int           // open interactive tabs in the window
 }
 
 func parseTabID(id string) (prefix, suffix string, err error) {
@@ -139,67 +58,43 @@ func parseTabID(id string) (prefix, suffix string, err error) {
 	return
 }
 
-func removeWindow(id string, windows, tabs *map[string]session) string {
+// removeWindow removes the window and its kept tabs, returning the IDs of
+// the tabs.
+func removeWindow(id string, windows, tabs *map[string]session) []string {
 	delete(*windows, id)
-	var tabLog []string
+	var removed []string
 	for tid := range *tabs {
-		prefix, suffix, _ := parseTabID(tid)
+		prefix, _, _ := parseTabID(tid)
 		if prefix == id {
-			tabLog = append(tabLog, fmt.Sprintf("_%s", suffix))
+			removed = append(removed, tid)
 			delete(*tabs, tid)
 		}
 	}
-	if len(tabLog) == 0 {
-		return fmt.Sprintf("Deleting window %s", id)
-	}
-	return fmt.Sprintf("Deleting window %s including tabs %v", id, tabLog)
-}
-
-func createWindow(id string) session {
-	var ctx context.Context
-	var cancel context.CancelFunc
-	if debugMode {
-		ctx, cancel = chromedp.NewExecAllocator(context.Background())
-	} else {
-		ctx, cancel = chromedp.NewContext(context.Background())
-	}
-	var w session
-	w.cancel = cancel
-	if len(id) < 8 {
-		w.id = createSessionID()
-	} else {
-		w.id = id
-	}
-
-	// create a persistent dummy tab to keep the window open
-	w.ctx, _ = chromedp.NewContext(ctx)
-	chromedp.Run(w.ctx, chromedp.Navigate("about:blank"))
-
-	return w
+	return removed
 }
 
 func createSessionID() string {
 	return fmt.Sprintf("%08x", rand.Int63()&0xffffffff)
 }
 
-func (ses session) createSiblingTabWithTimeout(timeout time.Duration) session {
-	if timeout > ses.timeout {
-		ses = loadWindow(ses.id, timeout)
-	}
+// createSiblingTab creates a tab which is closed after timeout, without
+// extending the idle timeout of the window.
+func (ses session) createSiblingTab(timeout time.Duration) session {
 	id := fmt.Sprintf("%s_%s", ses.id, createSessionID())
 	sibling := session{id: id, timeout: timeout}
 	sibling.ctx, _ = chromedp.NewContext(ses.ctx)
-	sibling.ctx, _ = context.WithTimeout(sibling.ctx, timeout)
+	var cancelTimeout context.CancelFunc
+	sibling.ctx, cancelTimeout = context.WithTimeout(sibling.ctx, timeout)
 	sibling.cancel = context.CancelFunc(func() {
 		chromedp.Run(sibling.ctx, page.Close())
+		cancelTimeout()
 	})
 	return sibling
 }
 
 func (ses *session) shutdown() {
 	if ses.cancel == nil {
-		msg := "Expected non-nil cancelFunc when shutting down tab/window (session %s)\n"
-		fmt.Fprintf(os.Stderr, msg, ses.id)
+		slog.Error("expected non-nil cancelFunc when shutting down tab/window", "session", ses.id)
 		return
 	}
 	ses.cancel()
@@ -211,6 +106,130 @@ func click(sel string) chromedp.ActionFunc {
 	}
 }
 
+// mouseTarget is either a CSS selector or a point in viewport coordinates.
+type mouseTarget struct {
+	sel  string
+	x, y float64
+}
+
+// parseMouseTarget interprets arg as a point if it has the form "x,y", and
+// as a selector otherwise.
+func parseMouseTarget(arg string) (mouseTarget, error) {
+	m := pointRegexp.FindStringSubmatch(arg)
+	if m == nil {
+		return mouseTarget{sel: arg}, nil
+	}
+	x, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return mouseTarget{}, fmt.Errorf(`invalid x coordinate "%s"`, m[1])
+	}
+	y, err := strconv.ParseFloat(m[2], 64)
+	if err != nil {
+		return mouseTarget{}, fmt.Errorf(`invalid y coordinate "%s"`, m[2])
+	}
+	return mouseTarget{x: x, y: y}, nil
+}
+
+// resolve returns the viewport coordinates of the target, scrolling a
+// selected node into view and using the center of its content box.
+func (t mouseTarget) resolve(ctx context.Context) (x, y float64, err error) {
+	if t.sel == "" {
+		return t.x, t.y, nil
+	}
+	var nodes []*cdp.Node
+	err = chromedp.Run(ctx, chromedp.Nodes(t.sel, &nodes, chromedp.NodeVisible))
+	if err != nil {
+		return
+	}
+	id := nodes[0].NodeID
+	if err = dom.ScrollIntoViewIfNeeded().WithNodeID(id).Do(ctx); err != nil {
+		return
+	}
+	quads, err := dom.GetContentQuads().WithNodeID(id).Do(ctx)
+	if err != nil {
+		return
+	}
+	if len(quads) == 0 || len(quads[0]) < 2 || len(quads[0])%2 != 0 {
+		err = fmt.Errorf(`element "%s" has no content box`, t.sel)
+		return
+	}
+	q := quads[0]
+	for i := 0; i < len(q); i += 2 {
+		x += q[i]
+		y += q[i+1]
+	}
+	x /= float64(len(q) / 2)
+	y /= float64(len(q) / 2)
+	return
+}
+
+func mouseClick(target mouseTarget, button input.MouseButton, count int) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		x, y, err := target.resolve(ctx)
+		if err != nil {
+			return err
+		}
+		err = input.DispatchMouseEvent(input.MouseMoved, x, y).Do(ctx)
+		if err != nil {
+			return err
+		}
+		// a double click is two consecutive clicks with increasing click count
+		for n := 1; n <= count; n++ {
+			err = chromedp.MouseClickXY(x, y,
+				chromedp.ButtonType(button), chromedp.ClickCount(n)).Do(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func hover(target mouseTarget) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		x, y, err := target.resolve(ctx)
+		if err != nil {
+			return err
+		}
+		return input.DispatchMouseEvent(input.MouseMoved, x, y).Do(ctx)
+	}
+}
+
+func drag(from, to mouseTarget) chromedp.ActionFunc {
+	const steps = 10
+	return func(ctx context.Context) error {
+		x0, y0, err := from.resolve(ctx)
+		if err != nil {
+			return fmt.Errorf("drag source: %s", err)
+		}
+		x1, y1, err := to.resolve(ctx)
+		if err != nil {
+			return fmt.Errorf("drag target: %s", err)
+		}
+		err = input.DispatchMouseEvent(input.MouseMoved, x0, y0).Do(ctx)
+		if err != nil {
+			return err
+		}
+		err = input.DispatchMouseEvent(input.MousePressed, x0, y0).
+			WithButton(input.Left).WithButtons(1).WithClickCount(1).Do(ctx)
+		if err != nil {
+			return err
+		}
+		// move in small steps so that pages listening for mousemove notice
+		for i := 1; i <= steps; i++ {
+			x := x0 + (x1-x0)*float64(i)/steps
+			y := y0 + (y1-y0)*float64(i)/steps
+			err = input.DispatchMouseEvent(input.MouseMoved, x, y).
+				WithButton(input.Left).WithButtons(1).Do(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return input.DispatchMouseEvent(input.MouseReleased, x1, y1).
+			WithButton(input.Left).WithClickCount(1).Do(ctx)
+	}
+}
+
 func elementExists(sel string, res *bool) chromedp.ActionFunc {
 	return func(ctx context.Context) error {
 		var nodes []*cdp.Node
@@ -297,17 +316,61 @@ func outerHTML(out *[]string) chromedp.ActionFunc {
 	}
 }
 
-func printToPDF(buf *[]byte, margins []float64) chromedp.ActionFunc {
+// pdfOptions holds the print_to_pdf settings. Paper dimensions are in inches
+// and zero values leave Chrome's defaults in place. Header and footer
+// templates may use the classes date, title, url, pageNumber and totalPages.
+type pdfOptions struct {
+	name                string
+	margins             [4]float64 // top, right, bottom, left
+	paperWidth          float64
+	paperHeight         float64
+	landscape           bool
+	scale               float64
+	printBackground     bool
+	pageRanges          string
+	preferCSSPageSize   bool
+	displayHeaderFooter bool
+	headerTemplate      string
+	footerTemplate      string
+}
+
+func printToPDF(opts pdfOptions, res *Result) chromedp.ActionFunc {
 	return func(ctx context.Context) error {
-		if len(margins) != 4 {
-			return fmt.Errorf("expected four margins (top, right, bottom, left)")
-		}
 		var err error
+		margins := opts.margins
 		p := page.PrintToPDF()
 		p = p.WithMarginTop(margins[0]).WithMarginBottom(margins[2])
 		p = p.WithMarginLeft(margins[3]).WithMarginRight(margins[1])
-		*buf, _, err = p.Do(ctx)
-		return err
+		if opts.paperWidth != 0 {
+			p = p.WithPaperWidth(opts.paperWidth)
+		}
+		if opts.paperHeight != 0 {
+			p = p.WithPaperHeight(opts.paperHeight)
+		}
+		if opts.scale != 0 {
+			p = p.WithScale(opts.scale)
+		}
+		if opts.pageRanges != "" {
+			p = p.WithPageRanges(opts.pageRanges)
+		}
+		p = p.WithLandscape(opts.landscape)
+		p = p.WithPrintBackground(opts.printBackground)
+		p = p.WithPreferCSSPageSize(opts.preferCSSPageSize)
+		if opts.displayHeaderFooter {
+			p = p.WithDisplayHeaderFooter(true)
+			if opts.headerTemplate != "" {
+				p = p.WithHeaderTemplate(opts.headerTemplate)
+			}
+			if opts.footerTemplate != "" {
+				p = p.WithFooterTemplate(opts.footerTemplate)
+			}
+		}
+		buf, _, err := p.Do(ctx)
+		if err != nil {
+			return err
+		}
+		res.addArtifact(opts.name, "pdf", buf)
+		return nil
 	}
 }
 
@@ -318,27 +381,98 @@ func removeElements(sel string) chromedp.ActionFunc {
 	}
 }
 
-func screenshot(args map[string]string, buf *[]byte) chromedp.ActionFunc {
+type screenshotOptions struct {
+	name           string
+	element        string
+	padding        string
+	format         page.CaptureScreenshotFormat
+	quality        int
+	clip           *page.Viewport
+	fullPage       bool
+	scale          float64
+	omitBackground bool
+}
+
+// elementRectCmd returns the position of an element relative to its document.
+const elementRectCmd = `(() => {
+	const e = document.querySelector('%s').getBoundingClientRect(),
+		t = document.documentElement.getBoundingClientRect();
+	return {x: e.left - t.left, y: e.top - t.top, width: e.width, height: e.height};
+})()`
+
+func screenshot(opts screenshotOptions, res *Result) chromedp.ActionFunc {
 	return func(ctx context.Context) error {
 		var err error
-		if sel, ok := args["element"]; ok {
-			if padding, ok := args["padding"]; ok {
+		var clip *page.Viewport
+		switch {
+		case opts.element != "":
+			sel := opts.element
+			if opts.padding != "" {
 				cmd := fmt.Sprintf(
 					"document.querySelector('%s').setAttribute('style', 'padding:%s')",
-					sel, padding,
+					sel, opts.padding,
 				)
 				err = chromedp.Run(ctx, chromedp.Evaluate(cmd, nil))
 				if err != nil {
 					return fmt.Errorf("failed to add padding: %s", err)
 				}
 			}
-			err = chromedp.Run(ctx, chromedp.Screenshot(sel, buf, chromedp.NodeVisible))
-		} else {
-			err = chromedp.Run(ctx, chromedp.FullScreenshot(buf, 100))
+			err = chromedp.Run(ctx, chromedp.WaitVisible(sel, chromedp.ByQuery))
+			if err != nil {
+				return fmt.Errorf("failed to capture screenshot: %s", err)
+			}
+			clip = new(page.Viewport)
+			err = chromedp.Evaluate(fmt.Sprintf(elementRectCmd, sel), clip).Do(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to locate element: %s", err)
+			}
+			// align with "Capture node screenshot" which can't handle fractions
+			x, y := math.Round(clip.X), math.Round(clip.Y)
+			clip.Width = math.Round(clip.Width + clip.X - x)
+			clip.Height = math.Round(clip.Height + clip.Y - y)
+			clip.X, clip.Y = x, y
+		case opts.clip != nil:
+			c := *opts.clip
+			clip = &c
+		case opts.fullPage || opts.scale != 1:
+			// capturing beyond the viewport or scaling requires an explicit
+			// clip of the page or the viewport, like chromedp.FullScreenshot
+			_, _, _, _, visual, content, err := page.GetLayoutMetrics().Do(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to get layout metrics: %s", err)
+			}
+			if opts.fullPage {
+				clip = &page.Viewport{Width: math.Ceil(content.Width), Height: math.Ceil(content.Height)}
+			} else {
+				clip = &page.Viewport{
+					X: visual.PageX, Y: visual.PageY,
+					Width: visual.ClientWidth, Height: visual.ClientHeight,
+				}
+			}
 		}
+
+		if opts.omitBackground {
+			err = emulation.SetDefaultBackgroundColorOverride().
+				WithColor(&cdp.RGBA{R: 0, G: 0, B: 0, A: 0}).Do(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to omit background: %s", err)
+			}
+			defer emulation.SetDefaultBackgroundColorOverride().Do(ctx)
+		}
+
+		p := page.CaptureScreenshot().WithFormat(opts.format).WithFromSurface(true)
+		if opts.quality >= 0 {
+			p = p.WithQuality(int64(opts.quality))
+		}
+		if clip != nil {
+			clip.Scale = opts.scale
+			p = p.WithClip(clip).WithCaptureBeyondViewport(true)
+		}
+		buf, err := p.Do(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to capture screenshot: %s", err)
 		}
+		res.addArtifact(opts.name, string(opts.format), buf)
 
 		return nil
 	}
@@ -350,8 +484,73 @@ func scrollToBottom() chromedp.ActionFunc {
 	}
 }
 
-func listen(id *string, events ...string) chromedp.ActionFunc {
+// scrollStepCmd scrolls one step (or one viewport height if the step is
+// zero) and reports the page height, whether the bottom has been reached and
+// the number of visible images still loading.
+const scrollStepCmd = `(() => {
+	document.documentElement.style.overflow = "";
+	document.body.style.overflow = "";
+	window.scrollBy(0, %d || window.innerHeight);
+	const el = document.scrollingElement || document.documentElement;
+	const pending = Array.from(document.images).filter(img => {
+		const rect = img.getBoundingClientRect();
+		return !img.complete && rect.bottom > 0 && rect.top < window.innerHeight;
+	}).length;
+	return {
+		height: el.scrollHeight,
+		bottom: window.scrollY + window.innerHeight >= el.scrollHeight - 1,
+		pending: pending,
+	};
+})()`
+
+type scrollOptions struct {
+	step          int
+	interval      time.Duration
+	stable        time.Duration
+	maxHeight     int
+	maxIterations int
+}
+
+type scrollState struct {
+	Height  int  `json:"height"`
+	Bottom  bool `json:"bottom"`
+	Pending int  `json:"pending"`
+}
+
+func scrollUntilStable(opts scrollOptions) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		cmd := fmt.Sprintf(scrollStepCmd, opts.step)
+		lastHeight := -1
+		lastChange := time.Now()
+		for i := 0; i < opts.maxIterations; i++ {
+			var st scrollState
+			if err := chromedp.Evaluate(cmd, &st).Do(ctx); err != nil {
+				return err
+			}
+			if st.Height != lastHeight {
+				lastHeight = st.Height
+				lastChange = time.Now()
+			}
+			if opts.maxHeight > 0 && st.Height >= opts.maxHeight {
+				break
+			}
+			if st.Bottom && st.Pending == 0 && time.Since(lastChange) >= opts.stable {
+				break
+			}
+			select {
+			case <-time.After(opts.interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		// return to the top so sticky elements are rendered in place
+		return chromedp.Evaluate("window.scrollTo(0, 0)", nil).Do(ctx)
+	}
+}
+
+func listen(r *Request, idle waitCondition, events ...string) chromedp.ActionFunc {
 	return func(ctx context.Context) error {
+		var mu sync.Mutex
 		mustEvents := make(map[string]bool)
 		for _, event := range events {
 			mustEvents[event] = true
@@ -359,21 +558,34 @@ func listen(id *string, events ...string) chromedp.ActionFunc {
 
 		ch := make(chan struct{})
 		cctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		catch := func(name string) {
+			mu.Lock()
+			defer mu.Unlock()
+			ok := mustEvents[name]
+			r.metrics().countListenEvent(name, ok)
+			if ok {
+				r.logger().Debug("caught tab event", "event", name)
+				delete(mustEvents, name)
+				if len(mustEvents) == 0 {
+					cancel()
+					close(ch)
+				}
+			} else {
+				r.logger().Debug("ignored tab event", "event", name)
+			}
+		}
+		if mustEvents[networkIdleEvent] {
+			go func() {
+				if idle(cctx) == nil {
+					catch(networkIdleEvent)
+				}
+			}()
+		}
 		chromedp.ListenTarget(cctx, func(ev interface{}) {
 			switch e := ev.(type) {
 			case *page.EventLifecycleEvent:
-				if ok := mustEvents[e.Name]; ok {
-					fmt.Fprintf(os.Stderr, "%s Tab event (session %s): Caught %s\n",
-						time.Now().Format("[15:04:05]"), *id, e.Name)
-					delete(mustEvents, e.Name)
-					if len(mustEvents) == 0 {
-						cancel()
-						close(ch)
-					}
-				} else {
-					fmt.Fprintf(os.Stderr, "%s Tab event (session %s): Ignored %s\n",
-						time.Now().Format("[15:04:05]"), *id, e.Name)
-				}
+				catch(e.Name)
 			}
 		})
 		select {
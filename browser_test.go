@@ -0,0 +1,300 @@
+package decap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// newTestEngine returns a headless engine, skipping the test if no browser
+// is installed.
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+	found := false
+	for _, name := range []string{
+		"headless_shell", "headless-shell", "chromium", "chromium-browser",
+		"google-chrome", "google-chrome-stable",
+	} {
+		if _, err := exec.LookPath(name); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Skip("no browser installed")
+	}
+	e := NewEngine(WithAllocatorOptions(chromedp.DefaultExecAllocatorOptions[:]...))
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestFullPageScreenshot(t *testing.T) {
+	e := newTestEngine(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `<!DOCTYPE html><body style="margin:0"><div style="height:3000px"></div></body>`)
+	}))
+	defer srv.Close()
+
+	body := fmt.Sprintf(`{
+		"emulate_viewport": {"width": 800, "height": 600},
+		"global_render_delay": "100ms",
+		"query": [{"actions": [["navigate", %q], ["screenshot"]]}]
+	}`, srv.URL)
+	r := new(Request)
+	if err := r.ParseRequest(strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Execute(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(res.ImgBuffer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Height <= 600 {
+		t.Errorf("screenshot is %dx%d, want it taller than the viewport", cfg.Width, cfg.Height)
+	}
+}
+
+// executeTestPage executes the actions on a page served with the given HTML,
+// returning the output of the block.
+func executeTestPage(t *testing.T, e *Engine, html string, actions ...string) []string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, html)
+	}))
+	t.Cleanup(srv.Close)
+
+	body := fmt.Sprintf(`{
+		"emulate_viewport": {"width": 800, "height": 600},
+		"global_render_delay": "100ms",
+		"query": [{"actions": [["navigate", %q], %s]}]
+	}`, srv.URL, strings.Join(actions, ", "))
+	r := new(Request)
+	if err := r.ParseRequest(strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Execute(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Out[0]
+}
+
+func TestParseMouseTarget(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want mouseTarget
+	}{
+		{"10,20", mouseTarget{x: 10, y: 20}},
+		{" -1.5 , 2. ", mouseTarget{x: -1.5, y: 2}},
+		{"#a", mouseTarget{sel: "#a"}},
+		{"10,20,30", mouseTarget{sel: "10,20,30"}},
+		{"a, b", mouseTarget{sel: "a, b"}},
+	}
+	for _, test := range tests {
+		got, err := parseMouseTarget(test.arg)
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %+v and %v, want %+v", test.arg, got, err, test.want)
+		}
+	}
+	if _, err := parseMouseTarget("1.2.3,4"); err == nil {
+		t.Error(`"1.2.3,4": no error`)
+	}
+}
+
+func TestMouseActions(t *testing.T) {
+	e := newTestEngine(t)
+	html := `<!DOCTYPE html><body style="margin:0">
+<div id="a" style="position:absolute;left:0;top:0;width:100px;height:100px"></div>
+<div id="b" style="position:absolute;left:200px;top:0;width:100px;height:100px"></div>
+<script>
+window.events = [];
+for (const type of ["mouseover", "click", "dblclick", "contextmenu", "mousedown", "mouseup"]) {
+	document.addEventListener(type, e => events.push(type + ":" + (e.target.id || "body")));
+}
+</script>`
+	out := executeTestPage(t, e, html,
+		`["hover", "#a"]`,
+		`["dblclick", "#a"]`,
+		`["context_click", "#b"]`,
+		`["click_at", "250", "50"]`,
+		`["drag", "#a", "250,50"]`,
+		`["eval", "events.join(' ')"]`,
+	)
+	events := strings.Join(out, " ")
+	for _, want := range []string{"mouseover:a", "dblclick:a", "contextmenu:b", "click:b", "mousedown:a", "mouseup:b"} {
+		if !strings.Contains(events, want) {
+			t.Errorf("no %s in events %q", want, events)
+		}
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	e := newTestEngine(t)
+	html := `<!DOCTYPE html><body>
+<script>
+setTimeout(() => {
+	const p = document.createElement("p");
+	p.id = "late";
+	document.body.append(p);
+	window.ready = true;
+}, 300);
+</script>`
+	out := executeTestPage(t, e, html,
+		`["wait_for", "exists", "#late", "timeout", "5s"]`,
+		`["wait_for", "js", "window.ready === true"]`,
+		`["wait_for", "url", "^http://127\\.0\\.0\\.1:"]`,
+		`["eval", "document.querySelectorAll('#late').length"]`,
+	)
+	if got := strings.Join(out, " "); !strings.Contains(got, "1") {
+		t.Errorf("got output %q", got)
+	}
+}
+
+func TestParseScrollOptions(t *testing.T) {
+	defaults := scrollOptions{
+		interval:      DefaultScrollInterval,
+		stable:        DefaultScrollStable,
+		maxHeight:     DefaultScrollMaxHeight,
+		maxIterations: DefaultScrollMaxIterations,
+	}
+	tests := []struct {
+		args map[string]string
+		want scrollOptions
+		err  string
+	}{
+		{nil, defaults, ""},
+		{
+			map[string]string{"step": "400", "interval": "100ms", "stable": "2s", "max_height": "0", "max_iterations": "5"},
+			scrollOptions{step: 400, interval: 100 * time.Millisecond, stable: 2 * time.Second, maxIterations: 5},
+			"",
+		},
+		{map[string]string{"interval": "1m"}, scrollOptions{
+			interval:      MaxRenderDelay,
+			stable:        DefaultScrollStable,
+			maxHeight:     DefaultScrollMaxHeight,
+			maxIterations: DefaultScrollMaxIterations,
+		}, ""},
+		{map[string]string{"stable": "1"}, scrollOptions{}, "invalid stable"},
+		{map[string]string{"step": "x"}, scrollOptions{}, "invalid step"},
+		{map[string]string{"step": "-1"}, scrollOptions{}, "negative step"},
+		{map[string]string{"max_height": "-1"}, scrollOptions{}, "negative max_height"},
+		{map[string]string{"max_iterations": "0"}, scrollOptions{}, "max_iterations must be at least 1"},
+	}
+	for _, test := range tests {
+		got, err := parseScrollOptions(test.args)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: got error %v, want it to contain %q", test.args, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%v: unexpected error %s", test.args, err)
+		case got != test.want:
+			t.Errorf("%v: got %+v, want %+v", test.args, got, test.want)
+		}
+	}
+}
+
+func TestScrollUntilStable(t *testing.T) {
+	e := newTestEngine(t)
+	html := `<!DOCTYPE html><body style="margin:0">
+<div id="feed"><div style="height:1000px"></div></div>
+<script>
+let loaded = 0;
+window.addEventListener("scroll", () => {
+	if (loaded < 3 && window.scrollY + window.innerHeight >= document.body.scrollHeight - 1) {
+		loaded++;
+		const div = document.createElement("div");
+		div.style.height = "1000px";
+		document.getElementById("feed").append(div);
+	}
+});
+</script>`
+	out := executeTestPage(t, e, html,
+		`["scroll_until_stable", "interval", "50ms", "stable", "300ms"]`,
+		`["eval", "[loaded, window.scrollY].join(',')"]`,
+	)
+	if got := strings.Join(out, " "); !strings.Contains(got, "3,0") {
+		t.Errorf("got output %q, want all items loaded and the page scrolled to the top", got)
+	}
+}
+
+func TestParsePDFOptions(t *testing.T) {
+	tests := []struct {
+		args []string
+		want pdfOptions
+		err  string
+	}{
+		{nil, pdfOptions{}, ""},
+		{[]string{"1", "0.5", "1", "0.5"}, pdfOptions{margins: [4]float64{1, 0.5, 1, 0.5}}, ""},
+		{[]string{"0", "0", "0", "0"}, pdfOptions{}, ""},
+		{[]string{"page_ranges", "1-5, 8, 11-"}, pdfOptions{pageRanges: "1-5, 8, 11-"}, ""},
+		{
+			[]string{"paper", "a4", "landscape", "true", "margin_left", "0.25", "name", "doc"},
+			pdfOptions{name: "doc", paperWidth: 8.27, paperHeight: 11.69, landscape: true, margins: [4]float64{0, 0, 0, 0.25}},
+			"",
+		},
+		{
+			[]string{"paper_width", "5", "paper_height", "7", "footer_template", "<span class=pageNumber></span>"},
+			pdfOptions{paperWidth: 5, paperHeight: 7, footerTemplate: "<span class=pageNumber></span>", displayHeaderFooter: true},
+			"",
+		},
+		{[]string{"1", "0.5"}, pdfOptions{}, "print_to_pdf"},
+		{[]string{"1", "x", "1", "1"}, pdfOptions{}, "expected floating point margins"},
+		{[]string{"margin_top", "-1"}, pdfOptions{}, "margins must be non-negative"},
+		{[]string{"paper", "a4", "paper_width", "5"}, pdfOptions{}, "mutually exclusive"},
+		{[]string{"scale", "3"}, pdfOptions{}, "invalid scale"},
+		{[]string{"page_ranges", "1-5, a"}, pdfOptions{}, "invalid page_ranges"},
+		{[]string{"landscape", "sideways"}, pdfOptions{}, "invalid landscape"},
+	}
+	s := &ActionScope{new(Request), specPrintToPDF}
+	for _, test := range tests {
+		got, err := parsePDFOptions(s, NewAction(append([]string{"print_to_pdf"}, test.args...)...))
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v, want it to contain %q", test.args, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error %s", test.args, err)
+		case got != test.want:
+			t.Errorf("%q: got %+v, want %+v", test.args, got, test.want)
+		}
+	}
+}
+
+func TestPrintToPDF(t *testing.T) {
+	e := newTestEngine(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `<!DOCTYPE html><p>hello</p>`)
+	}))
+	defer srv.Close()
+
+	body := fmt.Sprintf(`{
+		"global_render_delay": "100ms",
+		"query": [{"actions": [["navigate", %q], ["print_to_pdf", "1", "0.5", "1", "0.5"]]}]
+	}`, srv.URL)
+	r := new(Request)
+	if err := r.ParseRequest(strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Execute(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdf := res.PDFBuffer(); !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Errorf("got %d bytes, want a PDF", len(pdf))
+	}
+}
@@ -0,0 +1,272 @@
+// Package client is a Go client of the Decap HTTP API. Requests are built
+// with NewRequest and the action constructors, and results are returned as
+// decap.Result with artifacts decoded.
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	browsePath   = "/api/decap/v0/browse"
+	jobsPath     = "/api/decap/v0/jobs"
+	validatePath = "/api/decap/v0/validate"
+	schemaPath   = "/api/decap/v0/schema"
+	tabPath      = "/api/decap/v0/tab"
+
+	maxTraceSize = 256 << 20
+
+	DefaultBaseURL = "http://localhost:4531"
+)
+
+// Client calls a Decap server. The zero value isn't usable; use New.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// New returns a client of the server at baseURL (e.g.
+// "http://localhost:4531"), using http.DefaultClient.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+var (
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrNotFound       = errors.New("not found")
+	ErrConflict       = errors.New("conflict")
+	ErrNotAcceptable  = errors.New("not acceptable")
+	ErrGone           = errors.New("gone")
+	ErrExecution      = errors.New("execution failed")
+	ErrUnavailable    = errors.New("unavailable") // e.g. out of windows, try again later
+)
+
+// Error is an error response of the server. It matches one of the Err
+// variables above with errors.Is, depending on the status code.
+type Error struct {
+	StatusCode int
+	Message    string
+
+	// Trace is the zip archive of the trace of a failed request with
+	// tracing enabled, see Request.Trace.
+	Trace []byte
+
+	// Timings cover a failed request with timings enabled up to the
+	// failure, see Request.Timings.
+	Timings *decap.Timings
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("decap: %s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return target == ErrInvalidRequest
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusNotAcceptable:
+		return target == ErrNotAcceptable
+	case http.StatusGone:
+		return target == ErrGone
+	case http.StatusInternalServerError:
+		return target == ErrExecution
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	default:
+		return false
+	}
+}
+
+// responseError turns a non-2xx response into an *Error. The server's
+// plain text bodies have the form "<status text>: <message>".
+func responseError(resp *http.Response) error {
+	switch resp.Header.Get("Content-Type") {
+	case "application/zip":
+		return traceError(resp)
+	case "application/json":
+		return timingsError(resp)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	msg := strings.TrimSpace(string(body))
+	msg = strings.TrimPrefix(msg, http.StatusText(resp.StatusCode)+": ")
+	return &Error{StatusCode: resp.StatusCode, Message: msg}
+}
+
+// errorBody is the error and timings of a failed request, as found in a
+// JSON error response or the trace.json of a trace archive.
+type errorBody struct {
+	Error   string         `json:"error"`
+	Timings *decap.Timings `json:"timings"`
+}
+
+// timingsError returns the error of a JSON error response, which the server
+// sends for failed requests with timings enabled.
+func timingsError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	var body errorBody
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTraceSize)).Decode(&body); err != nil {
+		e.Message = fmt.Sprintf("reading error: %s", err)
+		return e
+	}
+	e.Message, e.Timings = body.Error, body.Timings
+	return e
+}
+
+// traceError returns the error of a trace archive, taking the message and
+// timings from its trace.json.
+func traceError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	var err error
+	e.Trace, err = io.ReadAll(io.LimitReader(resp.Body, maxTraceSize))
+	var body errorBody
+	if err == nil {
+		body, err = traceBody(e.Trace)
+	}
+	if err != nil {
+		e.Message = fmt.Sprintf("reading trace: %s", err)
+		return e
+	}
+	e.Message, e.Timings = body.Error, body.Timings
+	return e
+}
+
+func traceBody(archive []byte) (errorBody, error) {
+	var body errorBody
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return body, err
+	}
+	f, err := zr.Open("trace.json")
+	if err != nil {
+		return body, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&body)
+	return body, err
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response
+// into out unless it is nil. Responses with a status code other than those
+// in ok are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, body, out any, ok ...int) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if len(ok) == 0 {
+		ok = []int{http.StatusOK}
+	}
+	for _, status := range ok {
+		if resp.StatusCode != status {
+			continue
+		}
+		if out != nil {
+			if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return resp, fmt.Errorf("decap: decoding response: %s", err)
+			}
+		}
+		return resp, nil
+	}
+	return resp, responseError(resp)
+}
+
+// Browse executes the request synchronously. Artifacts are always returned
+// within the JSON result, whatever the ResponseFormat of req.
+func (c *Client) Browse(ctx context.Context, req *Request) (*decap.Result, error) {
+	body := req.body
+	body.ResponseFormat = "json"
+	res := new(decap.Result)
+	if _, err := c.do(ctx, http.MethodPost, browsePath, body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// BrowseRaw executes the request synchronously and returns the response
+// body in the ResponseFormat of req (the sole artifact, or else JSON, by
+// default) along with its content type.
+func (c *Client) BrowseRaw(ctx context.Context, req *Request) (body []byte, contentType string, err error) {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return nil, "", err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+browsePath, bytes.NewReader(buf))
+	if err != nil {
+		return nil, "", err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("Accept", "*/*")
+
+	resp, err := c.HTTPClient.Do(hreq)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp)
+	}
+	if body, err = io.ReadAll(resp.Body); err != nil {
+		return nil, "", fmt.Errorf("decap: reading response: %s", err)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// Validate checks the request without executing it. A request which the
+// server considers invalid is reported by the returned ValidationErrors
+// rather than by err.
+func (c *Client) Validate(ctx context.Context, req *Request) (*decap.Plan, []*decap.ValidationError, error) {
+	var v struct {
+		Errors []*decap.ValidationError `json:"errors"`
+		Plan   *decap.Plan              `json:"plan"`
+	}
+	_, err := c.do(ctx, http.MethodPost, validatePath, req, &v,
+		http.StatusOK, http.StatusUnprocessableEntity)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v.Plan, v.Errors, nil
+}
+
+// Schema returns the JSON Schema of requests served by the server.
+func (c *Client) Schema(ctx context.Context) (json.RawMessage, error) {
+	var schema json.RawMessage
+	if _, err := c.do(ctx, http.MethodGet, schemaPath, nil, &schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
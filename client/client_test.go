@@ -0,0 +1,298 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+// The tests of this file run against stubs of the server's responses, which
+// the real server can't easily be made to give without a browser. The client
+// is tested against the real handlers in cmd/decap.
+
+// decodeRequest decodes the request body like the server, checking the
+// headers set by the client.
+func decodeRequest(t *testing.T, req *http.Request) *decap.Request {
+	t.Helper()
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: Content-Type %q", req.Method, req.URL.Path, ct)
+	}
+	if accept := req.Header.Get("Accept"); accept != "application/json" {
+		t.Errorf("%s %s: Accept %q", req.Method, req.URL.Path, accept)
+	}
+	dec := new(decap.Request)
+	if err := json.NewDecoder(req.Body).Decode(dec); err != nil {
+		t.Errorf("%s %s: %s", req.Method, req.URL.Path, err)
+	}
+	return dec
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(status), msg), status)
+}
+
+func newTestClient(t *testing.T, mux *http.ServeMux) *Client {
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return New(srv.URL + "/")
+}
+
+func TestBrowse(t *testing.T) {
+	png := []byte("\x89PNG fake")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+browsePath, func(w http.ResponseWriter, req *http.Request) {
+		dec := decodeRequest(t, req)
+		if dec.Timeout != "30s" || dec.ResponseFormat != "json" || len(dec.Query) != 1 || len(dec.Query[0].Actions) != 2 {
+			t.Errorf("got request %+v", dec)
+		}
+		if got := dec.Query[0].Actions[1]; !slices.Equal(got, decap.NewAction("screenshot", "name", "front")) {
+			t.Errorf("got action %v", got)
+		}
+		writeJSON(w, http.StatusOK, &decap.Result{
+			Err:       []string{""},
+			Out:       [][]string{{"out"}},
+			TabID:     "tab",
+			WindowID:  "window",
+			Artifacts: []*decap.Artifact{{Name: "front", Type: "png", Data: png}},
+		})
+	})
+	c := newTestClient(t, mux)
+
+	req := NewRequest().
+		Timeout(30*time.Second).
+		ResponseFormat("png").
+		Block(Navigate("https://example.com"), Screenshot(Named("name", "front")))
+	res, err := c.Browse(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TabID != "tab" || res.WindowID != "window" || res.Out[0][0] != "out" {
+		t.Errorf("got result %+v", res)
+	}
+	a := res.Artifact("front")
+	if a == nil || a.Type != "png" || !bytes.Equal(a.Data, png) {
+		t.Errorf("got artifact %+v", a)
+	}
+}
+
+func traceArchive(t *testing.T, msg string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("trace.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewEncoder(f).Encode(map[string]any{"error": msg, "steps": []any{}})
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestErrors(t *testing.T) {
+	archive := traceArchive(t, "navigate: net::ERR_NAME_NOT_RESOLVED")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+browsePath, func(w http.ResponseWriter, req *http.Request) {
+		dec := decodeRequest(t, req)
+		switch {
+		case dec.Timings:
+			writeJSON(w, http.StatusInternalServerError, map[string]any{
+				"error":   "click: context deadline exceeded",
+				"timings": &decap.Timings{TotalMS: 20000},
+			})
+		case dec.Trace:
+			w.Header().Set("Content-Type", "application/zip")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(archive)
+		case dec.Timeout == "1s":
+			writeError(w, http.StatusInternalServerError, "context deadline exceeded")
+		case dec.SessionID != "":
+			writeError(w, http.StatusServiceUnavailable, "decap: too many open windows")
+		default:
+			writeError(w, http.StatusBadRequest, "query[0] must contain at least one action block")
+		}
+	})
+	c := newTestClient(t, mux)
+	ctx := context.Background()
+
+	tests := []struct {
+		req    *Request
+		target error
+		msg    string
+		trace  bool
+	}{
+		{NewRequest(), ErrInvalidRequest, "query[0] must contain at least one action block", false},
+		{NewRequest().Timeout(time.Second), ErrExecution, "context deadline exceeded", false},
+		{NewRequest().Window("window1"), ErrUnavailable, "decap: too many open windows", false},
+		{NewRequest().Trace(), ErrExecution, "navigate: net::ERR_NAME_NOT_RESOLVED", true},
+		{NewRequest().Timings(), ErrExecution, "click: context deadline exceeded", false},
+	}
+	for i, test := range tests {
+		_, err := c.Browse(ctx, test.req)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%d: got error %v, want *Error", i, err)
+			continue
+		}
+		if !errors.Is(err, test.target) {
+			t.Errorf("%d: %v doesn't match %v", i, err, test.target)
+		}
+		if e.Message != test.msg {
+			t.Errorf("%d: got message %q, want %q", i, e.Message, test.msg)
+		}
+		if got := e.Trace != nil; got != test.trace {
+			t.Errorf("%d: got trace %v, want %v", i, got, test.trace)
+		} else if test.trace && !bytes.Equal(e.Trace, archive) {
+			t.Errorf("%d: trace differs from the archive sent", i)
+		}
+		if got := e.Timings != nil; got != test.req.body.Timings {
+			t.Errorf("%d: got timings %v, want %v", i, got, test.req.body.Timings)
+		}
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		polls    int
+		canceled bool
+	)
+	const id = "job1"
+	status := func() *Job {
+		job := &Job{ID: id, Status: JobRunning, Created: time.Now()}
+		if polls >= 2 {
+			job.Status = JobDone
+		}
+		return job
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+jobsPath, func(w http.ResponseWriter, req *http.Request) {
+		if dec := decodeRequest(t, req); dec.CallbackURL != "https://example.com/done" {
+			t.Errorf("got callback URL %q", dec.CallbackURL)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		writeJSON(w, http.StatusAccepted, status())
+	})
+	mux.HandleFunc("GET "+jobsPath+"/{id}", func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.PathValue("id") != id || canceled {
+			http.NotFound(w, req)
+			return
+		}
+		polls++
+		writeJSON(w, http.StatusOK, status())
+	})
+	mux.HandleFunc("GET "+jobsPath+"/{id}/result", func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if st := status(); st.Status != JobDone {
+			writeError(w, http.StatusConflict, "job is "+st.Status)
+			return
+		}
+		writeJSON(w, http.StatusOK, &decap.Result{Out: [][]string{{"done"}}})
+	})
+	mux.HandleFunc("DELETE "+jobsPath+"/{id}", func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		canceled = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := newTestClient(t, mux)
+	ctx := context.Background()
+
+	job, err := c.SubmitJob(ctx, NewRequest().CallbackURL("https://example.com/done"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != id || job.Status != JobRunning {
+		t.Fatalf("got job %+v", job)
+	}
+	if _, err = c.JobResult(ctx, id); !errors.Is(err, ErrConflict) {
+		t.Errorf("result of running job: got %v, want ErrConflict", err)
+	}
+
+	res, err := c.WaitJob(ctx, id, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Out[0][0] != "done" {
+		t.Errorf("got result %+v", res)
+	}
+	if polls != 2 {
+		t.Errorf("polled %d times, want 2", polls)
+	}
+
+	if err = c.CancelJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Job(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("canceled job: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestSessionReuse(t *testing.T) {
+	var requests []*decap.Request
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+browsePath, func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, decodeRequest(t, req))
+		writeJSON(w, http.StatusOK, &decap.Result{
+			WindowID: "window1",
+			TabID:    fmt.Sprintf("window1-tab%d", len(requests)),
+		})
+	})
+	c := newTestClient(t, mux)
+	ctx := context.Background()
+
+	s := c.Session()
+	req := NewRequest().Block(Navigate("https://example.com"))
+	if _, err := s.Browse(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Browse(ctx, NewRequest().Block(Click("#next"))); err != nil {
+		t.Fatal(err)
+	}
+	if s.WindowID != "window1" || s.TabID != "window1-tab2" {
+		t.Errorf("got session window %q and tab %q", s.WindowID, s.TabID)
+	}
+
+	first, second := requests[0], requests[1]
+	if first.SessionID != "" || !first.ReuseWindow || !first.ReuseTab {
+		t.Errorf("first request has session %q, reuse window %v, reuse tab %v",
+			first.SessionID, first.ReuseWindow, first.ReuseTab)
+	}
+	if len(first.Query[0].Actions) != 1 {
+		t.Errorf("first request got actions %v", first.Query[0].Actions)
+	}
+	if second.SessionID != "window1" || !second.ReuseWindow || !second.ReuseTab {
+		t.Errorf("second request has session %q, reuse window %v, reuse tab %v",
+			second.SessionID, second.ReuseWindow, second.ReuseTab)
+	}
+	want := []decap.Action{LoadTab("window1-tab1"), Click("#next")}
+	if !slices.EqualFunc(second.Query[0].Actions, want, slices.Equal) {
+		t.Errorf("second request got actions %v, want %v", second.Query[0].Actions, want)
+	}
+
+	// the request passed to the session isn't modified
+	if len(req.body.Query[0].Actions) != 1 || req.body.SessionID != "" {
+		t.Errorf("session modified the request: %+v", req.body)
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+
+	DefaultPollInterval = time.Second
+)
+
+// Job is the status of an async job.
+type Job struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Progress struct {
+		Block  int `json:"block"`
+		Blocks int `json:"blocks"`
+	} `json:"progress"`
+	Error    string          `json:"error,omitempty"`
+	Created  time.Time       `json:"created"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Callback *CallbackStatus `json:"callback,omitempty"`
+}
+
+type CallbackStatus struct {
+	URL       string `json:"url"`
+	Delivered bool   `json:"delivered"`
+	Attempts  []struct {
+		Time       time.Time `json:"time"`
+		StatusCode int       `json:"status_code,omitempty"`
+		Error      string    `json:"error,omitempty"`
+	} `json:"attempts"`
+}
+
+// SubmitJob starts executing the request asynchronously.
+func (c *Client) SubmitJob(ctx context.Context, req *Request) (*Job, error) {
+	job := new(Job)
+	if _, err := c.do(ctx, http.MethodPost, jobsPath, req, job, http.StatusAccepted); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	job := new(Job)
+	if _, err := c.do(ctx, http.MethodGet, jobsPath+"/"+id, nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// JobResult returns the result of a finished job. It fails with
+// ErrConflict if the job is still running and with ErrExecution if it
+// failed.
+func (c *Client) JobResult(ctx context.Context, id string) (*decap.Result, error) {
+	res := new(decap.Result)
+	if _, err := c.do(ctx, http.MethodGet, jobsPath+"/"+id+"/result", nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CancelJob cancels the job if it is still running and deletes it.
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, jobsPath+"/"+id, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// WaitJob polls the job every interval (DefaultPollInterval if zero) until
+// it is no longer running, and returns its result.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*decap.Result, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if job.Status != JobRunning {
+			return c.JobResult(ctx, id)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+// Request builds the JSON document of a browse request. Its methods return
+// the request itself, so calls can be chained:
+//
+//	req := client.NewRequest().
+//		Timeout(30 * time.Second).
+//		Block(client.Navigate(url), client.Screenshot(client.Named("name", "front")))
+type Request struct {
+	body request
+}
+
+type request struct {
+	Query           []*decap.QueryBlock     `json:"query"`
+	CallbackURL     string                  `json:"callback_url,omitempty"`
+	EmulateViewport *decap.ViewportBlock    `json:"emulate_viewport,omitempty"`
+	NetworkIdle     *decap.NetworkIdleBlock `json:"network_idle,omitempty"`
+	RenderDelay     string                  `json:"global_render_delay"`
+	ResponseFormat  string                  `json:"response_format,omitempty"`
+	ReuseTab        bool                    `json:"reuse_tab,omitempty"`
+	ReuseWindow     bool                    `json:"reuse_window,omitempty"`
+	SessionID       string                  `json:"sessionid,omitempty"`
+	Timeout         string                  `json:"timeout,omitempty"`
+	Timings         bool                    `json:"timings,omitempty"`
+	Trace           bool                    `json:"trace,omitempty"`
+}
+
+// NewRequest returns an empty request with a render delay of zero.
+func NewRequest() *Request {
+	return &Request{body: request{RenderDelay: "0s"}}
+}
+
+func (r *Request) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.body)
+}
+
+// Block appends a query block of the given actions.
+func (r *Request) Block(actions ...decap.Action) *Request {
+	r.body.Query = append(r.body.Query, &decap.QueryBlock{Actions: actions})
+	return r
+}
+
+// Repeat sets the repeat count of the last block.
+func (r *Request) Repeat(n int) *Request {
+	if block := r.lastBlock(); block != nil {
+		block.Repeat = &n
+	}
+	return r
+}
+
+// While sets the condition of the last block, see ElementExists and
+// ElementVisible.
+func (r *Request) While(cond decap.Action) *Request {
+	if block := r.lastBlock(); block != nil {
+		block.While = &cond
+	}
+	return r
+}
+
+func (r *Request) lastBlock() *decap.QueryBlock {
+	if len(r.body.Query) == 0 {
+		return nil
+	}
+	return r.body.Query[len(r.body.Query)-1]
+}
+
+func (r *Request) RenderDelay(d time.Duration) *Request {
+	r.body.RenderDelay = d.String()
+	return r
+}
+
+func (r *Request) Timeout(d time.Duration) *Request {
+	r.body.Timeout = d.String()
+	return r
+}
+
+func (r *Request) Viewport(v decap.ViewportBlock) *Request {
+	r.body.EmulateViewport = &v
+	return r
+}
+
+func (r *Request) NetworkIdle(idle decap.NetworkIdleBlock) *Request {
+	r.body.NetworkIdle = &idle
+	return r
+}
+
+// CallbackURL sets the URL which an async job POSTs its result to.
+func (r *Request) CallbackURL(u string) *Request {
+	r.body.CallbackURL = u
+	return r
+}
+
+// Window runs the request in the window session with the given ID, keeping
+// the window open afterwards.
+func (r *Request) Window(id string) *Request {
+	r.body.SessionID = id
+	r.body.ReuseWindow = true
+	return r
+}
+
+// KeepTab keeps the tab open after the request, so its ID can be passed to
+// LoadTab later.
+func (r *Request) KeepTab() *Request {
+	r.body.ReuseTab = true
+	return r
+}
+
+// Timings includes a breakdown of where the time of the request went in
+// the result.
+func (r *Request) Timings() *Request {
+	r.body.Timings = true
+	return r
+}
+
+// ResponseFormat sets the format of the response body returned by
+// Client.BrowseRaw: "json", "multipart", "zip", "pdf", "png", "jpeg" or
+// "webp". Browse always asks for JSON.
+func (r *Request) ResponseFormat(format string) *Request {
+	r.body.ResponseFormat = format
+	return r
+}
+
+// Trace captures the URL, a screenshot and the console messages after each
+// action. If the request fails, the returned *Error carries the trace as a
+// zip archive. Successful requests don't return their trace, and it isn't
+// kept by batches or interactive tabs.
+func (r *Request) Trace() *Request {
+	r.body.Trace = true
+	return r
+}
+
+// Arg is a named argument of actions such as Screenshot and PrintToPDF.
+type Arg struct {
+	name, value string
+}
+
+// Named returns a named argument. Values of type bool, int, float64 and
+// time.Duration are formatted the way the server parses them.
+func Named(name string, value any) Arg {
+	var v string
+	switch x := value.(type) {
+	case string:
+		v = x
+	case bool:
+		v = strconv.FormatBool(x)
+	case int:
+		v = strconv.Itoa(x)
+	case float64:
+		v = formatFloat(x)
+	case time.Duration:
+		v = x.String()
+	default:
+		v = fmt.Sprint(x)
+	}
+	return Arg{name: name, value: v}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func action(name string, args ...string) decap.Action {
+	return decap.NewAction(append([]string{name}, args...)...)
+}
+
+func namedAction(name string, positional []string, args []Arg) decap.Action {
+	xa := action(name, positional...)
+	for _, arg := range args {
+		xa = append(xa, arg.name, arg.value)
+	}
+	return xa
+}
+
+// Point formats viewport coordinates as a Drag target.
+func Point(x, y float64) string {
+	return formatFloat(x) + "," + formatFloat(y)
+}
+
+func Click(selector string) decap.Action {
+	return action("click", selector)
+}
+
+func ClickAt(x, y float64) decap.Action {
+	return action("click_at", formatFloat(x), formatFloat(y))
+}
+
+func ContextClick(selector string) decap.Action {
+	return action("context_click", selector)
+}
+
+func DblClick(selector string) decap.Action {
+	return action("dblclick", selector)
+}
+
+// Drag drags from one target to another, each a selector or a Point.
+func Drag(from, to string) decap.Action {
+	return action("drag", from, to)
+}
+
+func Eval(js string) decap.Action {
+	return action("eval", js)
+}
+
+func HideNavButtons() decap.Action {
+	return action("hide_nav_buttons")
+}
+
+func Hover(selector string) decap.Action {
+	return action("hover", selector)
+}
+
+// Listen waits for the lifecycle events, by default the pageload events.
+func Listen(events ...string) decap.Action {
+	return action("listen", events...)
+}
+
+func LoadHTML(html string) decap.Action {
+	return action("load_html", html)
+}
+
+func LoadTab(tabID string) decap.Action {
+	return action("load_tab", tabID)
+}
+
+func Navigate(url string) decap.Action {
+	return action("navigate", url)
+}
+
+func OuterHTML() decap.Action {
+	return action("outer_html")
+}
+
+func PrintToPDF(args ...Arg) decap.Action {
+	return namedAction("print_to_pdf", nil, args)
+}
+
+func Remove(selectors ...string) decap.Action {
+	return action("remove", selectors...)
+}
+
+func RemoveInfoBoxes() decap.Action {
+	return action("remove_info_boxes")
+}
+
+func RemoveInfoSections() decap.Action {
+	return action("remove_info_sections")
+}
+
+func RemoveNavSections() decap.Action {
+	return action("remove_nav_sections")
+}
+
+func Screenshot(args ...Arg) decap.Action {
+	return namedAction("screenshot", nil, args)
+}
+
+// ScrollToBottom scrolls to the bottom of the page.
+func ScrollToBottom() decap.Action {
+	return action("scroll")
+}
+
+// ScrollTo scrolls the element into view.
+func ScrollTo(selector string) decap.Action {
+	return action("scroll", selector)
+}
+
+func ScrollUntilStable(args ...Arg) decap.Action {
+	return namedAction("scroll_until_stable", nil, args)
+}
+
+func Sleep(d time.Duration) decap.Action {
+	return action("sleep", d.String())
+}
+
+// SleepRenderDelay sleeps for the render delay of the request.
+func SleepRenderDelay() decap.Action {
+	return action("sleep")
+}
+
+// Conditions of WaitFor.
+const (
+	WaitExists      = "exists"
+	WaitVisible     = "visible"
+	WaitGone        = "gone"
+	WaitJS          = "js"
+	WaitURL         = "url"
+	WaitRequest     = "request"
+	WaitDOMStable   = "dom_stable"
+	WaitNetworkIdle = "network_idle"
+)
+
+// WaitFor polls until the condition holds for arg, which is a selector,
+// JavaScript, a regexp or a duration depending on the condition. Args may
+// set timeout and interval (and max_inflight and ignore for network_idle).
+func WaitFor(condition, arg string, args ...Arg) decap.Action {
+	return namedAction("wait_for", []string{condition, arg}, args)
+}
+
+// ElementExists is a While condition.
+func ElementExists(selector string) decap.Action {
+	return action("element_exists", selector)
+}
+
+// ElementVisible is a While condition.
+func ElementVisible(selector string) decap.Action {
+	return action("element_visible", selector)
+}
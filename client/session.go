@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/jobindex-open/decap"
+)
+
+// Session runs consecutive requests in the same window and tab, continuing
+// where the previous request left off.
+type Session struct {
+	c        *Client
+	WindowID string
+	TabID    string
+}
+
+// Session returns a new session. The window and tab are created by the
+// first request.
+func (c *Client) Session() *Session {
+	return &Session{c: c}
+}
+
+// Browse executes the request in the session's tab. The request must start
+// with navigate or load_html unless the session already has a tab.
+func (s *Session) Browse(ctx context.Context, req *Request) (*decap.Result, error) {
+	body := req.body
+	body.SessionID, body.ReuseWindow, body.ReuseTab = s.WindowID, true, true
+	if s.TabID != "" {
+		body.Query = append([]*decap.QueryBlock(nil), body.Query...)
+		if len(body.Query) == 0 {
+			body.Query = []*decap.QueryBlock{{}}
+		}
+		first := *body.Query[0]
+		first.Actions = append([]decap.Action{LoadTab(s.TabID)}, first.Actions...)
+		body.Query[0] = &first
+	}
+	res, err := s.c.Browse(ctx, &Request{body: body})
+	if err != nil {
+		return nil, err
+	}
+	s.WindowID, s.TabID = res.WindowID, res.TabID
+	return res, nil
+}
+
+// Tab is an interactive tab driven over a WebSocket connection, executing
+// one action at a time.
+type Tab struct {
+	conn     net.Conn
+	ID       string
+	WindowID string
+}
+
+// TabResult is the outcome of a single action in a Tab.
+type TabResult struct {
+	Out       []string          `json:"out"`
+	Artifacts []*decap.Artifact `json:"artifacts"`
+}
+
+type tabReply struct {
+	Type     string `json:"type"`
+	TabID    string `json:"tab_id"`
+	WindowID string `json:"window_id"`
+	Error    string `json:"error"`
+	Invalid  bool   `json:"invalid"`
+	TabResult
+}
+
+// OpenTab opens an interactive tab configured by the settings of req
+// (viewport, render delay, timeout and window). Its blocks are ignored.
+func (c *Client) OpenTab(ctx context.Context, req *Request) (*Tab, error) {
+	u := "ws" + strings.TrimPrefix(c.BaseURL, "http") + tabPath
+	conn, _, _, err := ws.Dial(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	settings := req.body
+	settings.Query = nil
+	t := &Tab{conn: conn}
+	reply, err := t.roundTrip(ctx, settings)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.ID, t.WindowID = reply.TabID, reply.WindowID
+	return t, nil
+}
+
+// Do executes the action. Actions rejected by the server fail with
+// ErrInvalidRequest.
+func (t *Tab) Do(ctx context.Context, xa decap.Action) (*TabResult, error) {
+	reply, err := t.roundTrip(ctx, map[string]any{"action": xa})
+	if err != nil {
+		return nil, err
+	}
+	return &reply.TabResult, nil
+}
+
+func (t *Tab) roundTrip(ctx context.Context, msg any) (*tabReply, error) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetDeadline(deadline)
+		defer t.conn.SetDeadline(time.Time{})
+	}
+	if err = wsutil.WriteClientText(t.conn, buf); err != nil {
+		return nil, err
+	}
+	buf, err = wsutil.ReadServerText(t.conn)
+	if err != nil {
+		return nil, err
+	}
+	reply := new(tabReply)
+	if err = json.Unmarshal(buf, reply); err != nil {
+		return nil, fmt.Errorf("decap: decoding reply: %s", err)
+	}
+	if reply.Type == "error" {
+		if reply.Invalid {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRequest, reply.Error)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrExecution, reply.Error)
+	}
+	return reply, nil
+}
+
+// Close closes the connection, which closes the tab.
+func (t *Tab) Close() error {
+	err := wsutil.WriteClientMessage(t.conn, ws.OpClose, nil)
+	return errors.Join(err, t.conn.Close())
+}
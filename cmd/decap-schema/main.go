@@ -0,0 +1,40 @@
+// Command decap-schema writes the JSON Schema of Decap requests, or with
+// -stubs the Go type stubs of requests and results. It is run by go generate
+// in the root of the repository.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jobindex-open/decap"
+)
+
+func main() {
+	out := flag.String("o", "-", "output `file`")
+	stubs := flag.Bool("stubs", false, "write Go type stubs rather than the JSON Schema")
+	flag.Parse()
+
+	var buf []byte
+	var err error
+	if *stubs {
+		buf, err = decap.TypeStubs()
+	} else {
+		buf, err = decap.JSONSchema()
+		buf = append(buf, '\n')
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decap-schema: %s\n", err)
+		os.Exit(1)
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(buf)
+	} else {
+		err = os.WriteFile(*out, buf, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decap-schema: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	batchPath            = "/api/decap/v0/batch"
+	DefaultBatchParallel = 4
+	maxBatchLineSize     = 64 << 20
+	batchWindowWait      = time.Second
+	batchMaxWindowWait   = 16 * time.Second
+	batchWindowTries     = 10
+)
+
+// batchItem is a single request document of a batch. Items which couldn't
+// be read carry the read error instead.
+type batchItem struct {
+	index int
+	raw   json.RawMessage
+	err   error
+}
+
+// batchResult is emitted once per item in completion order. ID echoes the
+// caller-supplied "id" field of the request document verbatim.
+type batchResult struct {
+	Index  int             `json:"index"`
+	ID     json.RawMessage `json:"id,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Result *decap.Result   `json:"result,omitempty"`
+}
+
+// readBatch sends the items of r, which contains either a JSON array of
+// request documents or one document per line (NDJSON), and closes items.
+func readBatch(r io.Reader, items chan<- batchItem) {
+	defer close(items)
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			if b[0] == '[' {
+				readBatchArray(br, items)
+				return
+			}
+			break
+		}
+		br.ReadByte()
+	}
+
+	sc := bufio.NewScanner(br)
+	sc.Buffer(nil, maxBatchLineSize)
+	for index := 0; sc.Scan(); {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items <- batchItem{index: index, raw: bytes.Clone(line)}
+		index++
+	}
+	if err := sc.Err(); err != nil {
+		items <- batchItem{index: -1, err: fmt.Errorf("reading batch: %s", err)}
+	}
+}
+
+func readBatchArray(r io.Reader, items chan<- batchItem) {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		items <- batchItem{index: -1, err: fmt.Errorf("reading batch: %s", err)}
+		return
+	}
+	for index := 0; dec.More(); index++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			// the rest of the array can't be trusted after a syntax error
+			items <- batchItem{index: index, err: fmt.Errorf("JSON parsing error: %s", err)}
+			return
+		}
+		items <- batchItem{index: index, raw: raw}
+	}
+}
+
+// executeBatch runs the items with the given parallelism, calling emit (from
+// multiple goroutines) as each item completes. Each worker keeps its own
+// window session unless an item names one itself. Items are logged with the
+// ID of the batch, if any, followed by their index. Once ctx is done, the
+// remaining items fail. The parallelism is capped by -max-windows, as each
+// worker needs a window.
+func executeBatch(ctx context.Context, items <-chan batchItem, parallel int, id string, emit func(batchResult)) {
+	if *maxWindows > 0 {
+		parallel = min(parallel, *maxWindows)
+	}
+	var wg sync.WaitGroup
+	for range parallel {
+		wg.Go(func() {
+			session := fmt.Sprintf("%08x", rand.Uint32())
+			for item := range items {
+				emit(executeBatchItem(ctx, item, session, id))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func executeBatchItem(ctx context.Context, item batchItem, session, id string) batchResult {
+	res := batchResult{Index: item.index}
+	if item.err != nil {
+		res.Error = item.err.Error()
+		return res
+	}
+
+	var tag struct {
+		ID json.RawMessage `json:"id"`
+	}
+	json.Unmarshal(item.raw, &tag)
+	res.ID = tag.ID
+
+	// windows of other requests (or earlier batches) are only closed once
+	// idle, so wait for one with backoff rather than failing the item. A
+	// request is executed only once, so each try decodes it afresh.
+	var err error
+	delay := batchWindowWait
+	for try := 1; ; try++ {
+		res.Result, err = executeBatchRequest(ctx, item, session, id)
+		if !errors.Is(err, decap.ErrTooManyWindows) || try == batchWindowTries {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		delay = min(2*delay, batchMaxWindowWait)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func executeBatchRequest(ctx context.Context, item batchItem, session, id string) (*decap.Result, error) {
+	dec := new(decap.Request)
+	err := dec.ParseRequest(bytes.NewReader(item.raw))
+	if id != "" {
+		dec.ID = fmt.Sprintf("%s/%d", id, item.index)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dec.CallbackURL != "" {
+		return nil, fmt.Errorf("callback_url is only supported by %s", jobsPath)
+	}
+	if dec.SessionID == "" {
+		dec.SessionID = session
+	}
+	return execute(ctx, dec)
+}
+
+// batchHandler streams NDJSON results in completion order. Failing items are
+// reported in their result line and don't affect the rest of the batch. The
+// query parameter "parallel" may lower the parallelism below maxParallel.
+// Items still running when the client goes away are canceled.
+func batchHandler(maxParallel int) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch req.Header.Get("Content-Type") {
+		case "application/json", "application/x-ndjson":
+		default:
+			status := http.StatusBadRequest
+			msg := fmt.Sprintf("%s: expected application/json or application/x-ndjson",
+				http.StatusText(status))
+			http.Error(w, msg, status)
+			return
+		}
+
+		parallel := maxParallel
+		if v := req.URL.Query().Get("parallel"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				status := http.StatusBadRequest
+				msg := fmt.Sprintf("%s: parallel must be a positive integer", http.StatusText(status))
+				http.Error(w, msg, status)
+				return
+			}
+			parallel = min(n, maxParallel)
+		}
+
+		// results are streamed while the request body is still being read
+		rc := http.NewResponseController(w)
+		rc.EnableFullDuplex()
+
+		items := make(chan batchItem)
+		go readBatch(req.Body, items)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		var mu sync.Mutex
+		id := requestID(req.Context())
+		executeBatch(req.Context(), items, parallel, id, func(res batchResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := enc.Encode(res); err != nil {
+				slog.Error("couldn't write batch result", "request_id", id, "index", res.Index, "error", err)
+				return
+			}
+			rc.Flush()
+		})
+	}
+}
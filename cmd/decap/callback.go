@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	signatureHeader       = "X-Decap-Signature"
+	timestampHeader       = "X-Decap-Timestamp"
+	jobIDHeader           = "X-Decap-Job"
+	callbackTimeout       = 30 * time.Second
+	callbackInitialDelay  = time.Second
+	callbackMaxDelay      = time.Minute
+	DefaultCallbackTries  = 5
+	callbackSecretEnvName = "DECAP_CALLBACK_SECRET"
+)
+
+// callbackPayload is the document POSTed to the callback URL. Result is
+// omitted if the job failed, in which case Job.Error says why.
+type callbackPayload struct {
+	Job    jobStatus     `json:"job"`
+	Result *decap.Result `json:"result,omitempty"`
+}
+
+type deliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type callbackStatus struct {
+	URL       string            `json:"url"`
+	Delivered bool              `json:"delivered"`
+	Attempts  []deliveryAttempt `json:"attempts"`
+}
+
+type callbackClient struct {
+	secret   []byte
+	tries    int
+	hosts    []string // allowed callback hosts, any if empty
+	delay    time.Duration
+	maxDelay time.Duration
+	client   *http.Client
+}
+
+// newCallbackClient returns a client signing callbacks with secret. If hosts
+// is non-empty, callback URLs must have one of them as host name.
+func newCallbackClient(secret string, tries int, hosts []string) *callbackClient {
+	return &callbackClient{
+		secret:   []byte(secret),
+		tries:    tries,
+		hosts:    hosts,
+		delay:    callbackInitialDelay,
+		maxDelay: callbackMaxDelay,
+		client: &http.Client{
+			Timeout: callbackTimeout,
+			// a redirect could lead anywhere, bypassing hosts
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// allowed checks the host of a callback URL against the allowed hosts.
+// Without an allow-list any host is accepted, including loopback and private
+// addresses reachable only from the server.
+func (c *callbackClient) allowed(callbackURL string) error {
+	if len(c.hosts) == 0 {
+		return nil
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(c.hosts, func(host string) bool {
+		return strings.EqualFold(host, u.Hostname())
+	}) {
+		return fmt.Errorf(`host "%s" is not allowed`, u.Hostname())
+	}
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>",
+// prefixed by the algorithm name like "sha256=<hex>". Signing the timestamp
+// lets receivers reject replayed deliveries.
+func (c *callbackClient) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver POSTs the finished job to its callback URL, retrying with
+// exponential backoff until a 2xx response, the tries run out or ctx is
+// done. Redirects aren't followed, but count as failed tries.
+func (c *callbackClient) deliver(ctx context.Context, j *job) {
+	st := j.snapshot()
+	st.Callback = nil
+	payload := callbackPayload{Job: st}
+	if st.Status == jobStatusDone {
+		payload.Result = j.res
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("couldn't encode callback", "request_id", j.req.ID, "job", j.id, "error", err)
+		return
+	}
+
+	delay := c.delay
+	for try := 1; try <= c.tries; try++ {
+		attempt := deliveryAttempt{Time: time.Now()}
+		attempt.StatusCode, err = c.post(ctx, j.req.CallbackURL, j.id, body)
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		delivered := err == nil && attempt.StatusCode/100 == 2
+		if !delivered && attempt.Error == "" {
+			attempt.Error = fmt.Sprintf("unexpected status %d", attempt.StatusCode)
+		}
+
+		j.mu.Lock()
+		j.callback.Attempts = append(j.callback.Attempts, attempt)
+		j.callback.Delivered = delivered
+		j.mu.Unlock()
+
+		if delivered {
+			return
+		}
+		slog.Warn("callback failed", "request_id", j.req.ID, "job", j.id,
+			"try", try, "tries", c.tries, "error", attempt.Error)
+		if try == c.tries {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		delay = min(2*delay, c.maxDelay)
+	}
+}
+
+func (c *callbackClient) post(ctx context.Context, url, id string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, c.sign(timestamp, body))
+	req.Header.Set(jobIDHeader, id)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+func newTestJob(callbackURL string) *job {
+	return &job{
+		id:       "job1",
+		status:   jobStatusDone,
+		created:  time.Now(),
+		finished: time.Now(),
+		req:      &decap.Request{CallbackURL: callbackURL},
+		res:      &decap.Result{Out: [][]string{{"hello"}}},
+		callback: &callbackStatus{URL: callbackURL, Attempts: []deliveryAttempt{}},
+	}
+}
+
+func TestCallbackDelivery(t *testing.T) {
+	type delivery struct {
+		signature, timestamp, jobID string
+		body                        []byte
+	}
+	var (
+		mu         sync.Mutex
+		deliveries []delivery
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries = append(deliveries, delivery{
+			req.Header.Get(signatureHeader), req.Header.Get(timestampHeader), req.Header.Get(jobIDHeader), body})
+		if len(deliveries) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c := newCallbackClient("secret", 5, nil)
+	c.delay = time.Millisecond
+	j := newTestJob(srv.URL)
+	c.deliver(context.Background(), j)
+
+	if len(deliveries) != 3 {
+		t.Fatalf("got %d deliveries, want 3", len(deliveries))
+	}
+	for i, d := range deliveries {
+		if want := c.sign(d.timestamp, d.body); d.timestamp == "" || !hmac.Equal([]byte(d.signature), []byte(want)) {
+			t.Errorf("delivery %d: signature %q, want %q", i, d.signature, want)
+		}
+		if d.jobID != j.id {
+			t.Errorf("delivery %d: job header %q, want %q", i, d.jobID, j.id)
+		}
+	}
+
+	var payload struct {
+		Job    jobStatus     `json:"job"`
+		Result *decap.Result `json:"result"`
+	}
+	if err := json.Unmarshal(deliveries[2].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Job.ID != j.id || payload.Job.Status != jobStatusDone {
+		t.Errorf("payload job is %+v", payload.Job)
+	}
+	if payload.Result == nil || len(payload.Result.Out) != 1 || payload.Result.Out[0][0] != "hello" {
+		t.Errorf("payload result is %+v", payload.Result)
+	}
+
+	st := j.snapshot()
+	if !st.Callback.Delivered || len(st.Callback.Attempts) != 3 {
+		t.Errorf("callback status is %+v", st.Callback)
+	}
+	if a := st.Callback.Attempts[0]; a.StatusCode != http.StatusServiceUnavailable || a.Error == "" {
+		t.Errorf("first attempt is %+v", a)
+	}
+}
+
+func TestCallbackGivesUp(t *testing.T) {
+	var tries int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tries++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := newCallbackClient("secret", 3, nil)
+	c.delay = time.Millisecond
+	j := newTestJob(srv.URL)
+	c.deliver(context.Background(), j)
+
+	if tries != 3 {
+		t.Errorf("got %d tries, want 3", tries)
+	}
+	if st := j.snapshot(); st.Callback.Delivered || len(st.Callback.Attempts) != 3 {
+		t.Errorf("callback status is %+v", st.Callback)
+	}
+}
+
+func TestCallbackNoRedirects(t *testing.T) {
+	var redirected bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		redirected = true
+	}))
+	defer internal.Close()
+	srv := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer srv.Close()
+
+	c := newCallbackClient("secret", 1, nil)
+	j := newTestJob(srv.URL)
+	c.deliver(context.Background(), j)
+
+	if redirected {
+		t.Error("callback followed a redirect")
+	}
+	st := j.snapshot()
+	if st.Callback.Delivered || st.Callback.Attempts[0].StatusCode != http.StatusFound {
+		t.Errorf("callback status is %+v", st.Callback)
+	}
+}
+
+func TestCallbackCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := newCallbackClient("secret", 5, nil)
+	c.delay = time.Hour
+	j := newTestJob(srv.URL)
+	done := make(chan struct{})
+	go func() {
+		c.deliver(ctx, j)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliver kept waiting after its context was canceled")
+	}
+	if n := len(j.snapshot().Callback.Attempts); n != 1 {
+		t.Errorf("got %d attempts, want 1", n)
+	}
+}
+
+func TestCallbackAllowedHosts(t *testing.T) {
+	c := newCallbackClient("secret", 1, parseList(" hooks.example.com, Other.example.com ,"))
+	for url, ok := range map[string]bool{
+		"https://hooks.example.com/done":      true,
+		"http://other.example.com:8080/done":  true,
+		"http://127.0.0.1/done":               false,
+		"http://169.254.169.254/latest":       false,
+		"https://hooks.example.com.evil/done": false,
+	} {
+		if err := c.allowed(url); (err == nil) != ok {
+			t.Errorf("allowed(%q) = %v, want allowed %v", url, err, ok)
+		}
+	}
+	if err := newCallbackClient("secret", 1, nil).allowed("http://127.0.0.1/"); err != nil {
+		t.Errorf("without an allow-list: %s", err)
+	}
+}
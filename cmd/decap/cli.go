@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+var unsafeFilenameRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage:
+  decap [flags] [serve]                  run the HTTP server (default)
+  decap run [-o file] request.json       execute a single request
+  decap batch [-out dir] [-parallel N] requests.jsonl
+                                         execute an NDJSON file of requests
+  decap validate request.json            check a request without executing it
+
+A file name of "-" reads from stdin.
+
+Server flags:
+`)
+	flag.PrintDefaults()
+}
+
+// parseInterspersed parses flags which may appear both before and after the
+// positional arguments, e.g. "run request.json -o out.png".
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// formatFromFilename infers the output format from the extension of the
+// output file, returning "" for unknown extensions.
+func formatFromFilename(name string) string {
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	return decap.FormatFromMediaType(ext)
+}
+
+// runCommand executes a single request without HTTP.
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	out := fs.String("o", "-", "output `file`; the format follows the extension (.json, .png, .pdf, .zip, ...)")
+	format := fs.String("format", "", "output format overriding the file extension")
+	trace := fs.String("trace", "", "trace the request, writing a zip `file` of the trace if it fails")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "decap run: expected a single request file")
+		return exitUsage
+	}
+
+	in, err := openInput(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decap run: %s\n", err)
+		return exitFailure
+	}
+	defer in.Close()
+
+	dec := new(decap.Request)
+	if err = dec.ParseRequest(in); err != nil {
+		fmt.Fprintf(os.Stderr, "decap run: %s\n", err)
+		return exitFailure
+	}
+
+	if *format == "" && *out != "-" {
+		*format = formatFromFilename(*out)
+	}
+	if *format == "" {
+		*format = dec.ResponseFormat
+	}
+	if *format == "" {
+		*format = "json"
+	}
+	if f := decap.FormatFromMediaType(*format); f != "" {
+		*format = f
+	} else {
+		fmt.Fprintf(os.Stderr, "decap run: unknown format \"%s\"\n", *format)
+		return exitUsage
+	}
+
+	if *trace != "" {
+		dec.Trace = true
+	}
+
+	startEngine()
+	defer engine.Close()
+	res, err := engine.Execute(context.Background(), dec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decap run: %s\n", err)
+		var terr *decap.TraceError
+		if *trace != "" && errors.As(err, &terr) {
+			if err = writeTraceFile(*trace, terr); err != nil {
+				fmt.Fprintf(os.Stderr, "decap run: %s\n", err)
+			}
+		}
+		return exitFailure
+	}
+	if err = producible(*format, res); err != nil {
+		fmt.Fprintf(os.Stderr, "decap run: %s\n", err)
+		return exitFailure
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			fmt.Fprintf(os.Stderr, "decap run: %s\n", err)
+			return exitFailure
+		}
+		defer w.Close()
+	}
+	if err = encodeResult(w, make(http.Header), *format, res); err != nil {
+		fmt.Fprintf(os.Stderr, "decap run: %s\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// batchSummaryItem is the per-item entry of the summary.json report.
+type batchSummaryItem struct {
+	Index int             `json:"index"`
+	ID    json.RawMessage `json:"id,omitempty"`
+	Error string          `json:"error,omitempty"`
+	Files []string        `json:"files"`
+}
+
+type batchSummary struct {
+	Total     int                `json:"total"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Duration  string             `json:"duration"`
+	Items     []batchSummaryItem `json:"items"`
+}
+
+// batchCommand executes an NDJSON file of requests, writing one result file
+// per item plus its artifacts and a summary.json to the output directory.
+func batchCommand(args []string) int {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	outDir := fs.String("out", ".", "output `dir`ectory")
+	parallel := fs.Int("parallel", DefaultBatchParallel, "number of requests executed concurrently")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 || *parallel < 1 {
+		fmt.Fprintln(os.Stderr, "decap batch: expected a single requests file and -parallel >= 1")
+		return exitUsage
+	}
+
+	in, err := openInput(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decap batch: %s\n", err)
+		return exitFailure
+	}
+	defer in.Close()
+	if err = os.MkdirAll(*outDir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "decap batch: %s\n", err)
+		return exitFailure
+	}
+
+	startEngine()
+	defer engine.Close()
+
+	start := time.Now()
+	var mu sync.Mutex
+	var summary batchSummary
+	taken := make(map[string]bool)
+	items := make(chan batchItem)
+	go readBatch(in, items)
+	executeBatch(context.Background(), items, *parallel, "", func(res batchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		base := batchFilename(res, taken)
+
+		item := batchSummaryItem{Index: res.Index, ID: res.ID, Error: res.Error, Files: []string{}}
+		if res.Result != nil {
+			files, err := writeBatchFiles(*outDir, base, res.Result)
+			item.Files = files
+			if err != nil && item.Error == "" {
+				item.Error = err.Error()
+			}
+		}
+
+		summary.Total++
+		if item.Error == "" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+			slog.Warn("batch item failed", "index", res.Index, "file", base, "error", item.Error)
+		}
+		summary.Items = append(summary.Items, item)
+	})
+	summary.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	buf, _ := json.MarshalIndent(summary, "", "  ")
+	if err = os.WriteFile(filepath.Join(*outDir, "summary.json"), buf, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "decap batch: %s\n", err)
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "%d requests: %d succeeded, %d failed (%s)\n",
+		summary.Total, summary.Succeeded, summary.Failed, summary.Duration)
+	if summary.Failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// batchFilename derives a file name prefix from the item's ID, falling back
+// to its index. Prefixes already taken (compared case-insensitively) get the
+// index appended, and so does "summary", which is reserved for the report.
+func batchFilename(res batchResult, taken map[string]bool) string {
+	id := string(bytes.TrimSpace(res.ID))
+	if s, err := strconv.Unquote(id); err == nil {
+		id = s
+	}
+	id = strings.Trim(unsafeFilenameRegexp.ReplaceAllString(id, "_"), "._")
+	if id == "" || id == "null" {
+		id = strconv.Itoa(res.Index)
+	}
+	base := id
+	for n := 1; taken[strings.ToLower(base)] || strings.EqualFold(base, "summary"); n++ {
+		base = fmt.Sprintf("%s_%d", id, res.Index)
+		if n > 1 {
+			base = fmt.Sprintf("%s_%d_%d", id, res.Index, n)
+		}
+	}
+	taken[strings.ToLower(base)] = true
+	return base
+}
+
+func writeBatchFiles(dir, base string, res *decap.Result) ([]string, error) {
+	var files []string
+	name := base + ".json"
+	buf, err := json.Marshal(resultWithoutArtifacts(res))
+	if err != nil {
+		return files, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, name), buf, 0o644); err != nil {
+		return files, err
+	}
+	files = append(files, name)
+	for _, a := range res.Artifacts {
+		name = fmt.Sprintf("%s.%s", base, a.Filename())
+		if err = os.WriteFile(filepath.Join(dir, name), a.Data, 0o644); err != nil {
+			return files, err
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+func writeTraceFile(name string, terr *decap.TraceError) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = writeTraceZip(f, terr); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBatchFilename(t *testing.T) {
+	taken := make(map[string]bool)
+	tests := []struct {
+		id    string
+		index int
+		want  string
+	}{
+		{`"a/b"`, 0, "a_b"},
+		{`"a_b"`, 1, "a_b_1"},
+		{`"a_b"`, 1, "a_b_1_2"},
+		{`"A_B"`, 2, "A_B_2"},
+		{`null`, 3, "3"},
+		{`"3"`, 4, "3_4"},
+		{`"summary"`, 5, "summary_5"},
+		{``, 6, "6"},
+		{`7`, 8, "7"},
+	}
+	for _, test := range tests {
+		res := batchResult{Index: test.index, ID: json.RawMessage(test.id)}
+		if got := batchFilename(res, taken); got != test.want {
+			t.Errorf("ID %s at %d: got %q, want %q", test.id, test.index, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/jobindex-open/decap"
+	"github.com/jobindex-open/decap/client"
+)
+
+// newTestClient returns a client of the server's handlers, executing
+// requests with a fresh headless engine.
+func newTestClient(t *testing.T) *client.Client {
+	t.Helper()
+	engine = decap.NewEngine(decap.WithAllocatorOptions(chromedp.DefaultExecAllocatorOptions[:]...))
+	t.Cleanup(func() { engine.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	jobs := newJobStore(ctx, time.Minute, nil)
+	srv := httptest.NewServer(drainHandler(requestIDHandler(newHandler(jobs))))
+	t.Cleanup(srv.Close)
+	return client.New(srv.URL + "/")
+}
+
+// skipWithoutBrowser skips tests executing requests if no browser is
+// installed.
+func skipWithoutBrowser(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"headless_shell", "headless-shell", "chromium", "chromium-browser",
+		"google-chrome", "google-chrome-stable",
+	} {
+		if _, err := exec.LookPath(name); err == nil {
+			return
+		}
+	}
+	t.Skip("no browser installed")
+}
+
+func TestClientValidate(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	plan, problems, err := c.Validate(ctx, client.NewRequest())
+	if err != nil || plan != nil || len(problems) != 1 || problems[0].Path != "query" {
+		t.Errorf("invalid request: got plan %v, problems %v and error %v", plan, problems, err)
+	}
+	req := client.NewRequest().
+		Timeout(10*time.Second).
+		Block(client.Navigate("https://example.com"), client.Screenshot(client.Named("format", "jpeg")))
+	plan, problems, err = c.Validate(ctx, req)
+	if err != nil || len(problems) > 0 || plan == nil {
+		t.Fatalf("valid request: got plan %v, problems %v and error %v", plan, problems, err)
+	}
+	if plan.Timeout != "10s" || len(plan.Query) != 1 || len(plan.Query[0].Actions) != 2 {
+		t.Errorf("got plan %+v", plan)
+	}
+}
+
+func TestClientSchema(t *testing.T) {
+	c := newTestClient(t)
+	schema, err := c.Schema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Schema string         `json:"$schema"`
+		Defs   map[string]any `json:"$defs"`
+	}
+	if err = json.Unmarshal(schema, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Schema == "" || doc.Defs["Request"] == nil {
+		t.Errorf("got schema %.100s", schema)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := c.Browse(ctx, client.NewRequest())
+	var e *client.Error
+	if !errors.As(err, &e) || !errors.Is(err, client.ErrInvalidRequest) {
+		t.Fatalf("browse without query: got %v, want ErrInvalidRequest", err)
+	}
+	if e.Message != "query[0] must contain at least one action block" {
+		t.Errorf("got message %q", e.Message)
+	}
+
+	req := client.NewRequest().CallbackURL("https://example.com/done").Block(client.Navigate("https://example.com"))
+	if _, err = c.Browse(ctx, req); !errors.Is(err, client.ErrInvalidRequest) {
+		t.Errorf("browse with callback: got %v, want ErrInvalidRequest", err)
+	}
+	if _, err = c.SubmitJob(ctx, req); !errors.Is(err, client.ErrInvalidRequest) {
+		t.Errorf("job with callback on a server without callbacks: got %v, want ErrInvalidRequest", err)
+	}
+
+	if _, err = c.Job(ctx, "nope"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("unknown job: got %v, want ErrNotFound", err)
+	}
+	if _, err = c.JobResult(ctx, "nope"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("result of unknown job: got %v, want ErrNotFound", err)
+	}
+	if err = c.CancelJob(ctx, "nope"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("canceling unknown job: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientBrowse(t *testing.T) {
+	skipWithoutBrowser(t)
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	req := client.NewRequest().
+		Timeout(30*time.Second).
+		Block(client.LoadHTML("<p>hello</p>"), client.OuterHTML(), client.Screenshot(client.Named("name", "page")))
+	res, err := c.Browse(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Out) != 1 || !strings.Contains(strings.Join(res.Out[0], ""), "hello") {
+		t.Errorf("got output %v", res.Out)
+	}
+	if a := res.Artifact("page"); a == nil || a.Type != "png" {
+		t.Errorf("got artifacts %v", res.Artifacts)
+	}
+
+	body, contentType, err := c.BrowseRaw(ctx, req.ResponseFormat("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/png" || !strings.HasPrefix(string(body), "\x89PNG") {
+		t.Errorf("got %d bytes of %s", len(body), contentType)
+	}
+}
+
+func TestClientJob(t *testing.T) {
+	skipWithoutBrowser(t)
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	job, err := c.SubmitJob(ctx, client.NewRequest().Block(client.LoadHTML("<p>hello</p>"), client.OuterHTML()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.WaitJob(ctx, job.ID, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Out) != 1 {
+		t.Errorf("got result %+v", res)
+	}
+	if job, err = c.Job(ctx, job.ID); err != nil || job.Status != client.JobDone {
+		t.Errorf("got job %+v and error %v", job, err)
+	}
+	if err = c.CancelJob(ctx, job.ID); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	healthPath          = "/healthz"
+	readyPath           = "/readyz"
+	diagnosticsPath     = "/api/decap/v0/diagnostics"
+	metricsPath         = "/metrics"
+	DefaultReadyTimeout = 5 * time.Second
+)
+
+var startTime = time.Now()
+
+// healthHandler reports that the process is alive.
+func healthHandler(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyHandler reports whether the server can render, by probing the engine
+// within timeout. A load balancer should stop sending requests once it
+// fails, which it also does when draining has begun.
+func readyHandler(timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		status := http.StatusServiceUnavailable
+		if draining.Load() {
+			http.Error(w, fmt.Sprintf("%s: draining", http.StatusText(status)), status)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		if _, err := engine.Probe(ctx); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(status), err), status)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+type diagnostics struct {
+	Version    string            `json:"browser_version,omitempty"`
+	ProbeError string            `json:"probe_error,omitempty"`
+	Engine     decap.EngineStats `json:"engine"`
+	QueueDepth int64             `json:"queue_depth"`
+	Draining   bool              `json:"draining"`
+	Uptime     string            `json:"uptime"`
+}
+
+// diagnosticsHandler reports the browser version and the state of the
+// engine. Requests are executed as they arrive rather than queued, so the
+// queue depth is the number of requests executing.
+func diagnosticsHandler(timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var diag diagnostics
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		version, err := engine.Probe(ctx)
+		if err != nil {
+			diag.ProbeError = err.Error()
+		}
+		diag.Version = version
+		diag.Engine = engine.Stats()
+		diag.QueueDepth = inflight.Load()
+		diag.Draining = draining.Load()
+		diag.Uptime = time.Since(startTime).Round(time.Second).String()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diag)
+	}
+}
+
+// metricsHandler serves the engine metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	engine.WriteMetrics(w)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	jobsPath               = "/api/decap/v0/jobs"
+	DefaultJobRetention    = 10 * time.Minute
+	jobStatusRunning       = "running"
+	jobStatusDone          = "done"
+	jobStatusFailed        = "failed"
+	jobStatusCanceled      = "canceled"
+	jobCollectionFrequency = 10 * time.Second
+)
+
+type jobProgress struct {
+	Block  int `json:"block"`
+	Blocks int `json:"blocks"`
+}
+
+// jobStatus is the JSON document returned by GET /jobs/{id}.
+type jobStatus struct {
+	ID       string          `json:"id"`
+	Status   string          `json:"status"`
+	Progress jobProgress     `json:"progress"`
+	Error    string          `json:"error,omitempty"`
+	Created  time.Time       `json:"created"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Callback *callbackStatus `json:"callback,omitempty"`
+}
+
+type job struct {
+	mu       sync.Mutex
+	id       string
+	status   string
+	err      error
+	created  time.Time
+	finished time.Time
+	req      *decap.Request
+	res      *decap.Result
+	cancel   context.CancelFunc
+	callback *callbackStatus
+}
+
+func (j *job) snapshot() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := jobStatus{ID: j.id, Status: j.status, Created: j.created}
+	st.Progress.Block, st.Progress.Blocks = j.req.Progress()
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		st.Finished = &finished
+	}
+	if j.callback != nil {
+		cb := *j.callback
+		cb.Attempts = append([]deliveryAttempt(nil), cb.Attempts...)
+		st.Callback = &cb
+	}
+	return st
+}
+
+func (j *job) run(ctx context.Context, callbacks *callbackClient) {
+	defer j.cancel()
+	res, err := execute(ctx, j.req)
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	switch {
+	case j.status == jobStatusCanceled:
+	case err != nil:
+		j.status, j.err = jobStatusFailed, err
+	default:
+		j.status, j.res = jobStatusDone, res
+	}
+	canceled := j.status == jobStatusCanceled
+	j.mu.Unlock()
+
+	if j.callback != nil && !canceled {
+		// deleting the job stops retries
+		callbacks.deliver(ctx, j)
+	}
+}
+
+// jobStore keeps jobs in memory until retention has passed since they
+// finished.
+type jobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	retention time.Duration
+	callbacks *callbackClient // nil if callbacks aren't configured
+}
+
+// newJobStore returns a job store collecting expired jobs until ctx is done.
+func newJobStore(ctx context.Context, retention time.Duration, callbacks *callbackClient) *jobStore {
+	s := &jobStore{
+		jobs:      make(map[string]*job),
+		retention: retention,
+		callbacks: callbacks,
+	}
+	go s.collect(ctx)
+	return s
+}
+
+func (s *jobStore) collect(ctx context.Context) {
+	ticker := time.NewTicker(jobCollectionFrequency)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		for id, j := range s.jobs {
+			j.mu.Lock()
+			expired := !j.finished.IsZero() && time.Since(j.finished) > s.retention
+			j.mu.Unlock()
+			if expired {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *jobStore) start(req *decap.Request) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		id:      rand.Text(),
+		status:  jobStatusRunning,
+		created: time.Now(),
+		req:     req,
+		cancel:  cancel,
+	}
+	if req.CallbackURL != "" {
+		j.callback = &callbackStatus{URL: req.CallbackURL, Attempts: []deliveryAttempt{}}
+	}
+	s.mu.Lock()
+	s.jobs[j.id] = j
+	s.mu.Unlock()
+	go j.run(ctx, s.callbacks)
+	return j
+}
+
+func (s *jobStore) get(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+func (s *jobStore) remove(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[id]
+	delete(s.jobs, id)
+	return j
+}
+
+func (s *jobStore) createHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/json" {
+		status := http.StatusBadRequest
+		msg := fmt.Sprintf("%s: expected application/json", http.StatusText(status))
+		http.Error(w, msg, status)
+		return
+	}
+
+	dec := new(decap.Request)
+	err := dec.ParseRequest(req.Body)
+	dec.ID = requestID(req.Context())
+	if err != nil {
+		status := http.StatusBadRequest
+		msg := fmt.Sprintf("%s: %s", http.StatusText(status), err)
+		http.Error(w, msg, status)
+		return
+	}
+	if dec.CallbackURL != "" && s.callbacks == nil {
+		status := http.StatusBadRequest
+		msg := fmt.Sprintf("%s: callback_url: callbacks are not enabled on this server",
+			http.StatusText(status))
+		http.Error(w, msg, status)
+		return
+	}
+	if dec.CallbackURL != "" {
+		if err := s.callbacks.allowed(dec.CallbackURL); err != nil {
+			status := http.StatusBadRequest
+			msg := fmt.Sprintf("%s: callback_url: %s", http.StatusText(status), err)
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	j := s.start(dec)
+	w.Header().Set("Location", fmt.Sprintf("%s/%s", jobsPath, j.id))
+	writeJobStatus(w, http.StatusAccepted, j.snapshot())
+}
+
+func (s *jobStore) statusHandler(w http.ResponseWriter, req *http.Request) {
+	j := s.get(req.PathValue("id"))
+	if j == nil {
+		http.NotFound(w, req)
+		return
+	}
+	writeJobStatus(w, http.StatusOK, j.snapshot())
+}
+
+func (s *jobStore) resultHandler(w http.ResponseWriter, req *http.Request) {
+	j := s.get(req.PathValue("id"))
+	if j == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	st := j.snapshot()
+	switch st.Status {
+	case jobStatusDone:
+	case jobStatusFailed:
+		j.mu.Lock()
+		err := j.err
+		j.mu.Unlock()
+		writeTraceError(w, http.StatusInternalServerError, err)
+		return
+	default:
+		status := http.StatusConflict
+		msg := fmt.Sprintf("%s: job is %s", http.StatusText(status), st.Status)
+		http.Error(w, msg, status)
+		return
+	}
+
+	format, err := negotiateFormat(j.req.ResponseFormat, parseAccept(req.Header.Get("Accept")), j.res)
+	if err != nil {
+		status := http.StatusNotAcceptable
+		msg := fmt.Sprintf("%s: %s", http.StatusText(status), err)
+		http.Error(w, msg, status)
+		return
+	}
+	writeResult(w, format, j.res)
+}
+
+// deleteHandler cancels the job if it is still running and forgets it.
+func (s *jobStore) deleteHandler(w http.ResponseWriter, req *http.Request) {
+	j := s.remove(req.PathValue("id"))
+	if j == nil {
+		http.NotFound(w, req)
+		return
+	}
+	j.mu.Lock()
+	if j.status == jobStatusRunning {
+		j.status = jobStatusCanceled
+	}
+	j.cancel() // also stops callback retries of a finished job
+	j.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJobStatus(w http.ResponseWriter, status int, st jobStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(st)
+	if err != nil {
+		slog.Error("couldn't encode job status", "job", st.ID, "error", err)
+	}
+}
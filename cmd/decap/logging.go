@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDRegexp limits the request IDs accepted from clients to ones which
+// are safe to log and echo back.
+var requestIDRegexp = regexp.MustCompile(`^[[:alnum:]._:/-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// setupLogging makes the default logger write to stderr at the level and in
+// the format given by the command line flags.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("-log-level: %s", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("-log-format: expected \"text\" or \"json\", got \"%s\"", *logFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// requestIDHandler gives every request an ID, taken from the X-Request-ID
+// header if the client sent a valid one and generated otherwise, which is
+// echoed back in the response and attached to the logs of the request.
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// requestID returns the ID given to the HTTP request by requestIDHandler,
+// or "" outside of one.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/jobindex-open/decap"
 )
@@ -24,6 +25,39 @@ const (
 var (
 	deprecatedAPIs []string
 	debugMode      = false
+	jobRetention   = flag.Duration("job-retention", DefaultJobRetention,
+		"how long results of finished async jobs are kept")
+	callbackSecret = flag.String("callback-secret", os.Getenv(callbackSecretEnvName),
+		"HMAC secret for signing job callbacks (callbacks are disabled if empty); callbacks are "+
+			"POSTed from the server, so restrict them with -callback-hosts if clients aren't trusted")
+	callbackHosts = flag.String("callback-hosts", "",
+		"comma separated `hosts` that job callbacks may be sent to (any host if empty)")
+	callbackTries = flag.Int("callback-tries", DefaultCallbackTries,
+		"number of attempts at delivering a job callback")
+	batchParallel = flag.Int("batch-parallel", DefaultBatchParallel,
+		"maximum number of batch items executed concurrently")
+	tabIdleTimeout = flag.Duration("tab-idle-timeout", DefaultTabIdleTimeout,
+		"how long an interactive WebSocket tab may go without messages")
+	tabOrigins = flag.String("tab-origins", "",
+		"comma separated `origins` of web pages allowed to open interactive tabs besides the server's own (* allows any)")
+	maxWindows = flag.Int("max-windows", 0,
+		"maximum number of simultaneously open browser windows (0 means no limit)")
+	traceSteps = flag.Int("trace-steps", decap.DefaultTraceSteps,
+		"number of steps kept in the trace of a failed request, the oldest being dropped")
+	windowTimeout = flag.Duration("window-timeout", decap.DefaultWindowTimeout,
+		"how long an unused browser window is kept open")
+	drainTimeout = flag.Duration("drain-timeout", DefaultDrainTimeout,
+		"how long a shutdown waits for in-flight requests before canceling them")
+	shutdownDelay = flag.Duration("shutdown-delay", 0,
+		"how long to keep serving after /readyz turns unhealthy on shutdown")
+	readyTimeout = flag.Duration("ready-timeout", DefaultReadyTimeout,
+		"how long /readyz waits for a test tab to evaluate JavaScript")
+	logLevel = flag.String("log-level", "info",
+		"minimum `level` of log messages (debug, info, warn or error)")
+	logFormat = flag.String("log-format", "text",
+		"log message `format` (text or json)")
+
+	engine *decap.Engine
 )
 
 func init() {
@@ -32,23 +66,61 @@ func init() {
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
-	go decap.AllocateSessions()
+	cmd := flag.Arg(0)
+	if cmd == "serve" {
+		// allow server flags after the subcommand too
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	if err := setupLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "decap: %s\n", err)
+		os.Exit(exitUsage)
+	}
 
-	var handler http.Handler
-	http.HandleFunc("/", http.NotFound)
+	switch cmd {
+	case "", "serve":
+		serve()
+	case "run":
+		os.Exit(runCommand(flag.Args()[1:]))
+	case "batch":
+		os.Exit(batchCommand(flag.Args()[1:]))
+	case "validate":
+		os.Exit(validateCommand(flag.Args()[1:]))
+	default:
+		fmt.Fprintf(os.Stderr, "decap: unknown command \"%s\"\n", cmd)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+}
 
-	handler = handleHTTPMethod(http.HandlerFunc(oldVersionFmtBrowseHandler))
-	http.Handle(browsePath, handler)
+// startEngine starts the engine executing requests, configured by the
+// command line flags.
+func startEngine() {
+	opts := []decap.EngineOption{
+		decap.WithMaxWindows(*maxWindows),
+		decap.WithWindowTimeout(*windowTimeout),
+		decap.WithTraceSteps(*traceSteps),
+	}
+	if debugMode {
+		opts = append(opts, decap.WithAllocatorOptions())
+	}
+	opts = append(opts, decap.WithLogger(slog.Default()))
+	engine = decap.NewEngine(opts...)
+	engine.Start()
+}
 
-	handler = handleHTTPMethod(http.HandlerFunc(browseHandler))
-	http.Handle(newBrowsePath, handler)
+func serve() {
+	startEngine()
 
-	handler = handleHTTPMethod(http.HandlerFunc(deprecationHandler))
-	for _, v := range deprecatedAPIs {
-		http.Handle(fmt.Sprintf("%s%s/", browsePath, v), handler)
+	var callbacks *callbackClient
+	if *callbackSecret != "" {
+		callbacks = newCallbackClient(*callbackSecret, *callbackTries, parseList(*callbackHosts))
 	}
+	// the collection of expired jobs stops once the server shuts down
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobs := newJobStore(jobsCtx, *jobRetention, callbacks)
 
 	var port int
 	if debugMode {
@@ -57,9 +129,50 @@ func main() {
 		port = DefaultPort
 	}
 
-	fmt.Fprintf(os.Stderr, "%s decap listening on http://localhost:%d%s\n",
-		time.Now().Format("[15:04:05]"), port, newBrowsePath)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	slog.Info("decap listening", "url", fmt.Sprintf("http://localhost:%d%s", port, newBrowsePath))
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: drainHandler(requestIDHandler(newHandler(jobs))),
+	}
+	srv.RegisterOnShutdown(stopJobs)
+	if err := listenAndDrain(srv, *shutdownDelay, *drainTimeout); err != nil {
+		slog.Error("serving failed", "error", err)
+		os.Exit(exitFailure)
+	}
+}
+
+// newHandler routes the API endpoints, configured by the command line flags,
+// to the handlers executing requests with engine.
+func newHandler(jobs *jobStore) http.Handler {
+	mux := http.NewServeMux()
+	var handler http.Handler
+	mux.HandleFunc("/", http.NotFound)
+
+	handler = handleHTTPMethod(http.HandlerFunc(oldVersionFmtBrowseHandler))
+	mux.Handle(browsePath, handler)
+
+	handler = handleHTTPMethod(http.HandlerFunc(browseHandler))
+	mux.Handle(newBrowsePath, handler)
+
+	mux.HandleFunc("POST "+jobsPath, jobs.createHandler)
+	mux.HandleFunc("GET "+jobsPath+"/{id}", jobs.statusHandler)
+	mux.HandleFunc("DELETE "+jobsPath+"/{id}", jobs.deleteHandler)
+	mux.HandleFunc("GET "+jobsPath+"/{id}/result", jobs.resultHandler)
+
+	mux.HandleFunc("POST "+batchPath, batchHandler(max(*batchParallel, 1)))
+	mux.HandleFunc("GET "+tabPath, tabHandler(*tabIdleTimeout, parseList(*tabOrigins)))
+	mux.HandleFunc("POST "+validatePath, validateHandler)
+	mux.HandleFunc("GET "+schemaPath, schemaHandler())
+	mux.HandleFunc("GET "+healthPath, healthHandler)
+	mux.HandleFunc("GET "+readyPath, readyHandler(*readyTimeout))
+	mux.HandleFunc("GET "+diagnosticsPath, diagnosticsHandler(*readyTimeout))
+	mux.HandleFunc("GET "+metricsPath, metricsHandler)
+
+	handler = handleHTTPMethod(http.HandlerFunc(deprecationHandler))
+	for _, v := range deprecatedAPIs {
+		mux.Handle(fmt.Sprintf("%s%s/", browsePath, v), handler)
+	}
+	return mux
 }
 
 func oldVersionFmtBrowseHandler(w http.ResponseWriter, req *http.Request) {
@@ -96,6 +209,7 @@ func browseHandler(w http.ResponseWriter, req *http.Request) {
 
 	var dec decap.Request
 	err := dec.ParseRequest(req.Body)
+	dec.ID = requestID(req.Context())
 	if err != nil {
 		status := http.StatusBadRequest
 		msg := fmt.Sprintf("%s: %s", http.StatusText(status), err)
@@ -103,53 +217,51 @@ func browseHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if dec.CallbackURL != "" {
+		status := http.StatusBadRequest
+		msg := fmt.Sprintf("%s: callback_url is only supported by %s",
+			http.StatusText(status), jobsPath)
+		http.Error(w, msg, status)
+		return
+	}
+
+	if wantsEventStream(req) {
+		streamExecute(w, req, &dec)
+		return
+	}
+
+	accepted := parseAccept(req.Header.Get("Accept"))
+	if dec.ResponseFormat == "" && !acceptsAnyFormat(accepted) {
+		status := http.StatusNotAcceptable
+		msg := fmt.Sprintf("%s: no supported media type in Accept header", http.StatusText(status))
+		http.Error(w, msg, status)
+		return
+	}
+
 	// execute query
 
 	err_status := http.StatusInternalServerError
 	var res *decap.Result
-	res, err = dec.Execute()
+	res, err = execute(req.Context(), &dec)
 	if err != nil {
 		// TODO: Propagate HTTP status properly
-		msg := fmt.Sprintf("%s: %s", http.StatusText(err_status), err)
-		http.Error(w, msg, err_status)
+		if errors.Is(err, decap.ErrTooManyWindows) {
+			err_status = http.StatusServiceUnavailable
+		}
+		writeTraceError(w, err_status, err)
 		return
 	}
 
 	// send response body
-	switch res.Type() {
-	case "json":
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(res)
-		if err != nil {
-			msg := fmt.Sprintf("%s: %s", http.StatusText(err_status), "Couldn't encode response")
-			http.Error(w, msg, err_status)
-		}
-		return
-	case "pdf":
-		w.Header().Set("Content-Type", "application/pdf")
-		_, err = w.Write(res.PDFBuffer())
-		if err != nil {
-			msg := fmt.Sprintf("%s: %s",
-				http.StatusText(err_status), "Couldn't write response bytes")
-			http.Error(w, msg, err_status)
-		}
-		return
-	case "png":
-		w.Header().Set("Content-Type", "image/png")
-		_, err = w.Write(res.ImgBuffer())
-		if err != nil {
-			msg := fmt.Sprintf("%s: %s",
-				http.StatusText(err_status), "Couldn't write response bytes")
-			http.Error(w, msg, err_status)
-		}
-		return
-	default:
-		fmt.Fprintf(os.Stderr, `unknown results type "%s"`, res.Type())
-		msg := fmt.Sprintf(`%s: Unknown result type "%s"`,
-			http.StatusText(err_status), res.Type())
-		http.Error(w, msg, err_status)
+
+	format, err := negotiateFormat(dec.ResponseFormat, accepted, res)
+	if err != nil {
+		status := http.StatusNotAcceptable
+		msg := fmt.Sprintf("%s: %s", http.StatusText(status), err)
+		http.Error(w, msg, status)
 		return
 	}
+	writeResult(w, format, res)
 }
 
 func deprecationHandler(w http.ResponseWriter, req *http.Request) {
@@ -205,3 +317,14 @@ func inferDeprecatedAPIs() []string {
 func autoDebuggingPort() int {
 	return DefaultPort - DefaultPort%1000 + 100 + os.Getuid()%100
 }
+
+// parseList splits a comma separated flag value, leaving out empty items.
+func parseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
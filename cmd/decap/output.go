@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jobindex-open/decap"
+)
+
+const resultFilename = "result.json"
+
+// parseAccept returns the media ranges of an Accept header ordered by
+// decreasing quality, leaving out those with q=0.
+func parseAccept(header string) []string {
+	type mediaRange struct {
+		typ string
+		q   float64
+	}
+	var ranges []mediaRange
+	for _, field := range strings.Split(header, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(field))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{typ, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	accepted := make([]string, len(ranges))
+	for i, r := range ranges {
+		accepted[i] = r.typ
+	}
+	return accepted
+}
+
+// responseFormats are the formats a result can be sent in, in order of
+// preference when a media range matches several.
+var responseFormats = []string{"json", "multipart", "zip", "pdf", "png", "jpeg", "webp"}
+
+// formatsInRange returns the response formats whose media type matches the
+// media range, e.g. "image/*" matches png, jpeg and webp.
+func formatsInRange(mediaRange string) []string {
+	mediaRange = strings.ToLower(mediaRange)
+	if mediaRange == "*/*" {
+		return responseFormats
+	}
+	if prefix, ok := strings.CutSuffix(mediaRange, "*"); ok && strings.HasSuffix(prefix, "/") {
+		var formats []string
+		for _, format := range responseFormats {
+			if strings.HasPrefix(decap.MediaType(format), prefix) {
+				formats = append(formats, format)
+			}
+		}
+		return formats
+	}
+	if format := decap.FormatFromMediaType(mediaRange); format != "" {
+		return []string{format}
+	}
+	return nil
+}
+
+// acceptsAnyFormat reports whether any of the accepted media ranges could
+// possibly be produced, so hopeless requests are rejected before executing.
+func acceptsAnyFormat(accepted []string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, typ := range accepted {
+		if len(formatsInRange(typ)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateFormat picks the response format from the request-level
+// response_format if set, and otherwise from the Accept header, falling
+// back to the result's own type.
+func negotiateFormat(requested string, accepted []string, res *decap.Result) (string, error) {
+	if requested != "" {
+		if err := producible(requested, res); err != nil {
+			return "", fmt.Errorf("response_format: %s", err)
+		}
+		return requested, nil
+	}
+	if len(accepted) == 0 {
+		return res.Type(), nil
+	}
+	for _, typ := range accepted {
+		// prefer the result's own type if the range allows it
+		formats := formatsInRange(typ)
+		if slices.Contains(formats, res.Type()) {
+			return res.Type(), nil
+		}
+		for _, format := range formats {
+			if producible(format, res) == nil {
+				return format, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("none of the accepted media types can be produced for this result")
+}
+
+// producible checks whether the result can be sent in the given format.
+// Binary formats require exactly one artifact of that type.
+func producible(format string, res *decap.Result) error {
+	switch format {
+	case "json", "multipart", "zip":
+		return nil
+	}
+	switch n := len(res.ArtifactsOfType(format)); n {
+	case 1:
+		return nil
+	case 0:
+		return fmt.Errorf("result contains no %s artifact", format)
+	default:
+		return fmt.Errorf("result contains %d %s artifacts, expected one", n, format)
+	}
+}
+
+// writeResult sends res in the given format, which must be producible.
+// Errors are only logged, as part of the response may already have been
+// sent.
+func writeResult(w http.ResponseWriter, format string, res *decap.Result) {
+	if err := encodeResult(w, w.Header(), format, res); err != nil {
+		slog.Error("couldn't write response", "format", format, "error", err)
+	}
+}
+
+// encodeResult writes res in the given format to w, setting Content-Type in
+// header before writing anything.
+func encodeResult(w io.Writer, header http.Header, format string, res *decap.Result) error {
+	switch format {
+	case "multipart":
+		return writeMultipart(w, header, res)
+	case "zip":
+		return writeZip(w, header, res)
+	case "json":
+		header.Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(res)
+	case "jpeg", "pdf", "png", "webp":
+		a := res.ArtifactsOfType(format)[0]
+		header.Set("Content-Type", a.ContentType())
+		_, err := w.Write(a.Data)
+		return err
+	default:
+		return fmt.Errorf(`unknown result type "%s"`, format)
+	}
+}
+
+// resultWithoutArtifacts returns a shallow copy of res for use alongside
+// artifacts which are sent separately.
+func resultWithoutArtifacts(res *decap.Result) *decap.Result {
+	meta := *res
+	meta.Artifacts = nil
+	return &meta
+}
+
+// writeMultipart sends the JSON result as the first part followed by one
+// part per artifact.
+func writeMultipart(w io.Writer, header http.Header, res *decap.Result) error {
+	mw := multipart.NewWriter(w)
+	header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Type", "application/json")
+	partHeader.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, resultFilename))
+	part, err := mw.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(part).Encode(resultWithoutArtifacts(res)); err != nil {
+		return err
+	}
+
+	for _, a := range res.Artifacts {
+		partHeader := make(textproto.MIMEHeader)
+		partHeader.Set("Content-Type", a.ContentType())
+		partHeader.Set("Content-Disposition",
+			fmt.Sprintf(`attachment; name="%s"; filename="%s"`, a.Name, a.Filename()))
+		part, err := mw.CreatePart(partHeader)
+		if err != nil {
+			return err
+		}
+		if _, err = part.Write(a.Data); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeZip sends a zip archive containing the JSON result and one file per
+// artifact.
+func writeZip(w io.Writer, header http.Header, res *decap.Result) error {
+	header.Set("Content-Type", "application/zip")
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create(resultFilename)
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(f).Encode(resultWithoutArtifacts(res)); err != nil {
+		return err
+	}
+
+	for _, a := range res.Artifacts {
+		f, err := zw.Create(a.Filename())
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(a.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
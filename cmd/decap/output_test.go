@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jobindex-open/decap"
+)
+
+func TestParseAccept(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"application/json", []string{"application/json"}},
+		{"image/*;q=0.5, application/pdf", []string{"application/pdf", "image/*"}},
+		{"text/html;q=0.9, image/png;q=0.9, */*;q=0.1", []string{"text/html", "image/png", "*/*"}},
+		{"image/webp;q=0, image/png", []string{"image/png"}},
+		{"image/png;q=high, ;;, application/zip", []string{"application/zip"}},
+	}
+	for _, test := range tests {
+		if got := parseAccept(test.header); !slices.Equal(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestFormatsInRange(t *testing.T) {
+	tests := []struct {
+		mediaRange string
+		want       []string
+	}{
+		{"*/*", responseFormats},
+		{"image/*", []string{"png", "jpeg", "webp"}},
+		{"Application/PDF", []string{"pdf"}},
+		{"multipart/mixed", []string{"multipart"}},
+		{"text/html", nil},
+		{"text/*", nil},
+	}
+	for _, test := range tests {
+		if got := formatsInRange(test.mediaRange); !slices.Equal(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.mediaRange, got, test.want)
+		}
+	}
+	if acceptsAnyFormat([]string{"text/html", "text/*"}) {
+		t.Error("text media ranges accepted")
+	}
+	if !acceptsAnyFormat(nil) || !acceptsAnyFormat([]string{"text/html", "image/*"}) {
+		t.Error("producible media ranges not accepted")
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	png := &decap.Artifact{Name: "screenshot", Type: "png", Data: []byte("png")}
+	pdf := &decap.Artifact{Name: "pdf", Type: "pdf", Data: []byte("pdf")}
+	onePNG := &decap.Result{Artifacts: []*decap.Artifact{png}}
+	pngAndPDF := &decap.Result{Artifacts: []*decap.Artifact{png, pdf}}
+	twoPNGs := &decap.Result{Artifacts: []*decap.Artifact{png, png}}
+
+	tests := []struct {
+		requested string
+		accept    string
+		res       *decap.Result
+		want      string // "" if not acceptable
+	}{
+		{"", "", onePNG, "png"},
+		{"", "", pngAndPDF, "json"},
+		{"", "*/*", onePNG, "png"},
+		{"", "application/json", onePNG, "json"},
+		{"", "image/*", pngAndPDF, "png"},
+		{"", "application/pdf, */*;q=0.1", pngAndPDF, "pdf"},
+		{"", "image/jpeg, application/zip;q=0.5", onePNG, "zip"},
+		{"", "image/jpeg", onePNG, ""},
+		{"", "image/png", twoPNGs, ""},
+		{"", "text/html", onePNG, ""},
+		{"json", "image/png", onePNG, "json"},
+		{"pdf", "", pngAndPDF, "pdf"},
+		{"pdf", "", onePNG, ""},
+	}
+	for _, test := range tests {
+		got, err := negotiateFormat(test.requested, parseAccept(test.accept), test.res)
+		switch {
+		case test.want == "" && err == nil:
+			t.Errorf("%q, Accept %q: got %s, want an error", test.requested, test.accept, got)
+		case test.want != "" && (err != nil || got != test.want):
+			t.Errorf("%q, Accept %q: got %q and %v, want %s", test.requested, test.accept, got, err, test.want)
+		}
+	}
+}
+
+func TestBrowseNotAcceptable(t *testing.T) {
+	body := `{"global_render_delay": "1s", "query": [{"actions": [["navigate", "https://example.com"], ["screenshot"]]}]}`
+	req := httptest.NewRequest("POST", newBrowsePath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/html, text/plain;q=0.5")
+	w := httptest.NewRecorder()
+	browseHandler(w, req)
+	if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), "no supported media type") {
+		t.Errorf("got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	DefaultDrainTimeout = 30 * time.Second
+	drainPollInterval   = 100 * time.Millisecond
+)
+
+var (
+	// draining is set once a shutdown has begun
+	draining atomic.Bool
+
+	// inflight counts executing requests and tab actions, which a shutdown
+	// waits for
+	inflight atomic.Int64
+
+	errDraining = errors.New("shutting down")
+)
+
+// execute executes a request with the engine, keeping count of it so a
+// shutdown can wait for it to finish.
+func execute(ctx context.Context, dec *decap.Request) (*decap.Result, error) {
+	inflight.Add(1)
+	defer inflight.Add(-1)
+	return engine.Execute(ctx, dec)
+}
+
+// openTab opens an interactive tab, keeping count of it like execute, unless
+// a shutdown has begun.
+func openTab(ctx context.Context, dec *decap.Request) (*decap.Tab, error) {
+	inflight.Add(1)
+	defer inflight.Add(-1)
+	if draining.Load() {
+		return nil, errDraining
+	}
+	return engine.OpenTab(ctx, dec)
+}
+
+// doTabAction executes an action of an interactive tab, keeping count of it
+// like execute. Tabs outlive their upgrade request, so actions are rejected
+// with errDraining once a shutdown has begun.
+func doTabAction(ctx context.Context, tab *decap.Tab, xa decap.Action) (*decap.Result, error) {
+	inflight.Add(1)
+	defer inflight.Add(-1)
+	if draining.Load() {
+		return nil, errDraining
+	}
+	return tab.Do(ctx, xa)
+}
+
+var drainExempt = map[string]bool{healthPath: true, readyPath: true, metricsPath: true}
+
+// drainHandler rejects all requests but health checks and metrics while
+// draining.
+func drainHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if draining.Load() && !drainExempt[req.URL.Path] {
+			status := http.StatusServiceUnavailable
+			w.Header().Set("Connection", "close")
+			http.Error(w, fmt.Sprintf("%s: shutting down", http.StatusText(status)), status)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// listenAndDrain serves until SIGTERM or SIGINT, then drains: readiness
+// flips to unhealthy, and after delay the server stops accepting
+// connections and waits up to timeout for in-flight requests before the
+// browsers are closed.
+func listenAndDrain(srv *http.Server, delay, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+
+	draining.Store(true)
+	slog.Info("draining", "in_flight", inflight.Load())
+	time.Sleep(delay)
+
+	deadline, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(deadline)
+	if err == nil {
+		// neither async jobs nor tabs on hijacked WebSocket connections
+		// are waited for by Shutdown
+		err = waitIdle(deadline)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("drain timeout exceeded, canceling requests", "in_flight", inflight.Load())
+	}
+	engine.Close()
+	slog.Info("shut down")
+	return nil
+}
+
+func waitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	eventStreamType  = "text/event-stream"
+	sseEventResult   = "result"
+	sseEventFailure  = "error"
+	streamQueryParam = "stream"
+)
+
+// wantsEventStream reports whether the client opted in to progress
+// streaming, either through the Accept header or "?stream=sse".
+func wantsEventStream(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), eventStreamType) ||
+		req.URL.Query().Get(streamQueryParam) == "sse"
+}
+
+// streamExecute executes dec while sending its progress as Server-Sent
+// Events, ending with either a "result" or an "error" event. The stream is
+// aborted if the client disconnects.
+func streamExecute(w http.ResponseWriter, req *http.Request, dec *decap.Request) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, v any) {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			slog.Error("couldn't encode event", "request_id", dec.ID, "event", event, "error", err)
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, buf)
+		rc.Flush()
+	}
+
+	dec.OnEvent = func(ev decap.Event) {
+		send(ev.Type, ev)
+	}
+	res, err := execute(req.Context(), dec)
+	if err != nil {
+		send(sseEventFailure, struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+	send(sseEventResult, res)
+}
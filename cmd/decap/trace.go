@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	traceFilename       = "trace.zip"
+	traceReportFilename = "index.html"
+)
+
+// traceReport renders the steps of a trace as a page of screenshots.
+var traceReport = template.Must(template.New("trace").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>decap trace</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.step { border-top: 1px solid #ccc; padding: 1em 0; }
+.error { color: #b00; }
+img { max-width: 640px; border: 1px solid #ccc; }
+pre { background: #f4f4f4; padding: .5em; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>decap trace</h1>
+<p class="error">{{.Error}}</p>
+{{if .Dropped}}<p>{{.Dropped}} earlier steps were dropped.</p>{{end}}
+{{range .Steps}}
+<div class="step">
+<h2>query[{{.Block}}].actions[{{.Action}}] {{.Name}}{{if .Iteration}} (iteration {{.Iteration}}){{end}}</h2>
+<p>{{.URL}} ({{.DurationMS}} ms)</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Screenshot}}<img src="{{.Screenshot}}" alt="screenshot">{{end}}
+{{if .Console}}<pre>{{range .Console}}{{.}}
+{{end}}</pre>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+// traceStep refers to the screenshot by its filename in the zip archive.
+type traceStep struct {
+	*decap.TraceStep
+	Screenshot string `json:"screenshot,omitempty"`
+}
+
+// writeTraceZip writes a zip archive of the trace of a failed request: a
+// trace.json of the steps with the error (and the timings, if requested), a
+// screenshot per step and an HTML report showing them.
+func writeTraceZip(w io.Writer, terr *decap.TraceError) error {
+	zw := zip.NewWriter(w)
+	report := struct {
+		Error   string         `json:"error"`
+		Timings *decap.Timings `json:"timings,omitempty"`
+		Steps   []traceStep    `json:"steps"`
+		Dropped int            `json:"dropped,omitempty"`
+	}{Error: terr.Err.Error(), Steps: []traceStep{}, Dropped: terr.Trace.Dropped}
+	var tierr *decap.TimingsError
+	if errors.As(terr.Err, &tierr) {
+		report.Timings = tierr.Timings
+	}
+
+	for i, step := range terr.Trace.Steps {
+		ts := traceStep{TraceStep: step}
+		if len(step.Screenshot) > 0 {
+			ts.Screenshot = fmt.Sprintf("step_%03d.jpeg", i)
+			f, err := zw.Create(ts.Screenshot)
+			if err != nil {
+				return err
+			}
+			if _, err = f.Write(step.Screenshot); err != nil {
+				return err
+			}
+		}
+		report.Steps = append(report.Steps, ts)
+	}
+
+	f, err := zw.Create("trace.json")
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(f).Encode(report); err != nil {
+		return err
+	}
+	if f, err = zw.Create(traceReportFilename); err != nil {
+		return err
+	}
+	if err = traceReport.Execute(f, report); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// timingsError is the JSON response of a failed request with timings but
+// without a trace.
+type timingsError struct {
+	Error   string         `json:"error"`
+	Timings *decap.Timings `json:"timings"`
+}
+
+// writeTraceError responds with the trace of a failed request as a zip
+// archive, or with its timings as JSON, falling back to a plain error if err
+// carries neither.
+func writeTraceError(w http.ResponseWriter, status int, err error) {
+	var terr *decap.TraceError
+	if errors.As(err, &terr) {
+		var buf bytes.Buffer
+		zerr := writeTraceZip(&buf, terr)
+		if zerr == nil {
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, traceFilename))
+			w.WriteHeader(status)
+			w.Write(buf.Bytes())
+			return
+		}
+		slog.Error("couldn't write trace", "error", zerr)
+	}
+	var tierr *decap.TimingsError
+	if errors.As(err, &tierr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if jerr := json.NewEncoder(w).Encode(timingsError{err.Error(), tierr.Timings}); jerr != nil {
+			slog.Warn("couldn't write timings", "error", jerr)
+		}
+		return
+	}
+	msg := fmt.Sprintf("%s: %s", http.StatusText(status), err)
+	http.Error(w, msg, status)
+}
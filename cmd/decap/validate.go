@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	validatePath = "/api/decap/v0/validate"
+	schemaPath   = "/api/decap/v0/schema"
+)
+
+// validation is the report returned by the validate endpoint and command.
+// Plan is only present if the request is valid.
+type validation struct {
+	Valid  bool                     `json:"valid"`
+	Errors []*decap.ValidationError `json:"errors"`
+	Plan   *decap.Plan              `json:"plan,omitempty"`
+}
+
+func validate(r io.Reader) validation {
+	plan, errs := new(decap.Request).Validate(r)
+	if errs == nil {
+		errs = []*decap.ValidationError{}
+	}
+	return validation{Valid: len(errs) == 0, Errors: errs, Plan: plan}
+}
+
+// validateHandler parses a request without executing it, responding with
+// 200 OK if it is valid and 422 Unprocessable Entity otherwise.
+func validateHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/json" {
+		status := http.StatusBadRequest
+		msg := fmt.Sprintf("%s: expected application/json", http.StatusText(status))
+		http.Error(w, msg, status)
+		return
+	}
+
+	v := validate(req.Body)
+	status := http.StatusOK
+	if !v.Valid {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("couldn't encode validation", "request_id", requestID(req.Context()), "error", err)
+	}
+}
+
+// validateCommand prints the validation report of a request file, exiting
+// with exitFailure if the request is invalid.
+func validateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "decap validate: expected a single request file")
+		return exitUsage
+	}
+
+	in, err := openInput(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decap validate: %s\n", err)
+		return exitFailure
+	}
+	defer in.Close()
+
+	v := validate(in)
+	buf, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(buf))
+	if !v.Valid {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// schemaHandler serves the JSON Schema of requests generated once from the
+// Go types, so clients can validate requests before sending them.
+func schemaHandler() http.HandlerFunc {
+	schema, err := decap.JSONSchema()
+	if err != nil {
+		slog.Error("couldn't generate request schema", "error", err)
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err != nil {
+			status := http.StatusInternalServerError
+			msg := fmt.Sprintf("%s: generating request schema: %s", http.StatusText(status), err)
+			http.Error(w, msg, status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(schema)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/jobindex-open/decap"
+)
+
+const (
+	tabPath               = "/api/decap/v0/tab"
+	DefaultTabIdleTimeout = time.Minute
+)
+
+// tabMessage is sent by the client once per action after the initial
+// settings message. ID is echoed verbatim in the reply.
+type tabMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Action decap.Action    `json:"action"`
+}
+
+// tabReply is sent by the server once when the tab is open (type "open")
+// and once per action (type "result" or "error"). Invalid is set if the
+// action was rejected without being executed.
+type tabReply struct {
+	Type      string            `json:"type"`
+	ID        json.RawMessage   `json:"id,omitempty"`
+	TabID     string            `json:"tab_id,omitempty"`
+	WindowID  string            `json:"window_id,omitempty"`
+	Out       []string          `json:"out,omitempty"`
+	Artifacts []*decap.Artifact `json:"artifacts,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Invalid   bool              `json:"invalid,omitempty"`
+}
+
+// allowedOrigin reports whether a WebSocket upgrade with the Origin header of
+// req may proceed. Browsers send it, so web pages on other sites can't drive
+// tabs unless their origin (e.g. "https://example.com") is in origins, or
+// origins contains "*". Requests without Origin come from other clients.
+func allowedOrigin(req *http.Request, origins []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" || slices.Contains(origins, "*") || slices.Contains(origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// tabHandler upgrades to a WebSocket connection driving a single tab. The
+// first message holds the tab settings in the format of a browse request
+// (without query), and every following message holds a single action. The
+// tab is closed when the client disconnects or sends nothing for
+// idleTimeout, and opening the tab or a running action is aborted if the
+// client disconnects.
+func tabHandler(idleTimeout time.Duration, origins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !allowedOrigin(req, origins) {
+			status := http.StatusForbidden
+			msg := fmt.Sprintf("%s: origin not allowed", http.StatusText(status))
+			http.Error(w, msg, status)
+			return
+		}
+		conn, _, _, err := ws.UpgradeHTTP(req, w)
+		if err != nil {
+			slog.Warn("WebSocket upgrade failed", "request_id", requestID(req.Context()), "error", err)
+			return
+		}
+		defer conn.Close()
+
+		msg, err := readTabMessage(conn, idleTimeout)
+		if err != nil {
+			return
+		}
+		dec := &decap.Request{ID: requestID(req.Context())}
+		if err = json.Unmarshal(msg, dec); err != nil {
+			writeTabReply(conn, tabReply{Type: "error", Error: fmt.Sprintf("JSON parsing error: %s", err), Invalid: true})
+			return
+		}
+
+		// keep reading while the tab opens or an action runs, so that they
+		// are aborted as soon as the client goes away
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		msgs := make(chan []byte)
+		go func() {
+			defer cancel()
+			for {
+				msg, err := readTabMessage(conn, 0)
+				if err != nil {
+					return
+				}
+				select {
+				case msgs <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		tab, err := openTab(ctx, dec)
+		if err != nil {
+			writeTabReply(conn, tabReply{Type: "error", Error: err.Error()})
+			return
+		}
+		defer tab.Close()
+		if err = writeTabReply(conn, tabReply{Type: "open", TabID: tab.ID(), WindowID: tab.WindowID()}); err != nil {
+			return
+		}
+
+		idle := time.NewTimer(idleTimeout)
+		defer idle.Stop()
+		for {
+			var msg []byte
+			idle.Reset(idleTimeout)
+			select {
+			case msg = <-msgs:
+			case <-idle.C:
+				return
+			case <-ctx.Done():
+				return
+			}
+			var tm tabMessage
+			if err = json.Unmarshal(msg, &tm); err != nil {
+				err = writeTabReply(conn, tabReply{Type: "error", Error: fmt.Sprintf("JSON parsing error: %s", err), Invalid: true})
+				if err != nil {
+					return
+				}
+				continue
+			}
+
+			reply := tabReply{Type: "result", ID: tm.ID, TabID: tab.ID(), WindowID: tab.WindowID()}
+			res, err := doTabAction(ctx, tab, tm.Action)
+			if res != nil {
+				reply.Out, reply.Artifacts = res.Out[0], res.Artifacts
+			}
+			if err != nil {
+				var perr *decap.ParseError
+				reply.Type, reply.Error, reply.Invalid = "error", err.Error(), errors.As(err, &perr)
+			}
+			// the tab is closed once the server is shutting down
+			if werr := writeTabReply(conn, reply); werr != nil || errors.Is(err, errDraining) {
+				return
+			}
+		}
+	}
+}
+
+// readTabMessage returns the next text message, skipping other frames. It
+// waits at most idleTimeout, or indefinitely if it is zero.
+func readTabMessage(conn net.Conn, idleTimeout time.Duration) ([]byte, error) {
+	for {
+		var deadline time.Time
+		if idleTimeout > 0 {
+			deadline = time.Now().Add(idleTimeout)
+		}
+		conn.SetReadDeadline(deadline)
+		msg, op, err := wsutil.ReadClientData(conn)
+		if err != nil {
+			return nil, err
+		}
+		if op == ws.OpText {
+			return msg, nil
+		}
+	}
+}
+
+func writeTabReply(conn net.Conn, reply tabReply) error {
+	buf, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return wsutil.WriteServerText(conn, buf)
+}
@@ -0,0 +1,551 @@
+package decap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	DefaultWindowTimeout = 30 * time.Second
+	windowGCInterval     = 2 * time.Second
+)
+
+var (
+	ErrEngineClosed   = errors.New("decap: engine is closed")
+	ErrTooManyWindows = errors.New("decap: too many open windows")
+)
+
+// Engine owns the browser windows and tabs used for executing requests.
+// Each engine runs its own browsers, so several differently configured
+// engines can be used side by side.
+type Engine struct {
+	execAllocator bool
+	allocatorOpts []chromedp.ExecAllocatorOption
+	maxWindows    int
+	traceSteps    int
+	windowTimeout time.Duration
+	logger        *slog.Logger
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	start   sync.Once
+	close   sync.Once
+	stopped chan struct{}
+	started time.Time
+
+	// probeWindowID is the window session used by Probe, which doesn't
+	// count toward maxWindows
+	probeWindowID string
+
+	metrics *engineMetrics
+
+	executing   atomic.Int64
+	interactive atomic.Int64
+
+	tabLoadQuery chan tabLoadQuery
+	tabSave      chan session
+	windowQuery  chan windowQuery
+	windowPin    chan windowPin
+	statsQuery   chan chan EngineStats
+}
+
+// windowQuery asks for the window session id, creating it if needed. The
+// reply is buffered, so the loop doesn't block on callers giving up.
+type windowQuery struct {
+	id      string
+	timeout time.Duration
+	reply   chan session
+}
+
+// tabLoadQuery takes the kept tab id out of the engine. Like windowQuery,
+// the reply is buffered.
+type tabLoadQuery struct {
+	id    string
+	reply chan session
+}
+
+// windowPin adds delta to the number of interactive tabs keeping a window
+// from being garbage collected.
+type windowPin struct {
+	id    string
+	delta int
+}
+
+// EngineOption configures an Engine, see NewEngine.
+type EngineOption func(*Engine)
+
+// WithAllocatorOptions launches browsers with the given options rather
+// than the chromedp defaults. Without options, the browser window is
+// visible, which is useful for debugging; pass
+// chromedp.DefaultExecAllocatorOptions[:] plus your own for a headless
+// browser.
+func WithAllocatorOptions(opts ...chromedp.ExecAllocatorOption) EngineOption {
+	return func(e *Engine) {
+		e.execAllocator = true
+		e.allocatorOpts = opts
+	}
+}
+
+// WithMaxWindows limits the number of simultaneously open windows (window
+// sessions). Requests needing another window fail with ErrTooManyWindows.
+// The window of Probe isn't counted. Zero means no limit.
+func WithMaxWindows(n int) EngineOption {
+	return func(e *Engine) {
+		e.maxWindows = n
+	}
+}
+
+// WithTraceSteps sets the number of steps kept in the trace of a request
+// with Request.Trace set, the oldest steps being dropped first
+// (DefaultTraceSteps by default).
+func WithTraceSteps(n int) EngineOption {
+	return func(e *Engine) {
+		e.traceSteps = n
+	}
+}
+
+// WithWindowTimeout sets the minimum time a window is kept open after it
+// was last used. Windows used by requests with a longer timeout are kept
+// open for that long instead.
+func WithWindowTimeout(d time.Duration) EngineOption {
+	return func(e *Engine) {
+		e.windowTimeout = d
+	}
+}
+
+// WithLogger sets the logger of window and query progress (slog.Default()
+// by default).
+func WithLogger(l *slog.Logger) EngineOption {
+	return func(e *Engine) {
+		e.logger = l
+	}
+}
+
+// WithLogOutput logs window and query progress as text to w.
+func WithLogOutput(w io.Writer) EngineOption {
+	return WithLogger(slog.New(slog.NewTextHandler(w, nil)))
+}
+
+// NewEngine returns an engine which must be started with Start before use.
+func NewEngine(opts ...EngineOption) *Engine {
+	e := &Engine{
+		windowTimeout: DefaultWindowTimeout,
+		traceSteps:    DefaultTraceSteps,
+		probeWindowID: createSessionID(),
+		metrics:       newEngineMetrics(),
+		stopped:       make(chan struct{}),
+		tabLoadQuery:  make(chan tabLoadQuery),
+		tabSave:       make(chan session),
+		windowQuery:   make(chan windowQuery),
+		windowPin:     make(chan windowPin),
+		statsQuery:    make(chan chan EngineStats),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.logger == nil {
+		e.logger = slog.Default()
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e
+}
+
+// Start starts allocating windows and tabs in the background. Calling it
+// again has no effect, and it is called by Execute, OpenTab, Probe and
+// Stats if needed.
+func (e *Engine) Start() {
+	e.start.Do(func() {
+		e.started = time.Now()
+		go e.run()
+	})
+}
+
+// Close closes all windows and tabs, failing requests still executing.
+// Requests submitted after Close fail with ErrEngineClosed.
+func (e *Engine) Close() error {
+	e.close.Do(func() {
+		e.cancel()
+		e.Start() // make sure there is a loop to stop
+	})
+	<-e.stopped
+	return nil
+}
+
+// Execute executes a parsed request, stopping when ctx is done.
+func (e *Engine) Execute(ctx context.Context, r *Request) (*Result, error) {
+	e.Start()
+	e.executing.Add(1)
+	defer e.executing.Add(-1)
+
+	start := time.Now()
+	res, err := e.execute(ctx, r)
+	if err != nil && r.res.Timings != nil {
+		err = &TimingsError{Err: err, Timings: r.res.Timings}
+	}
+	if err != nil && r.tracer != nil {
+		err = &TraceError{Err: err, Trace: r.tracer.result()}
+	}
+	e.metrics.observeRequest(start, err)
+	if err != nil {
+		r.logger().Warn("request failed", "duration", time.Since(start), "error", err)
+	} else {
+		r.logger().Info("request done", "duration", time.Since(start))
+	}
+	e.metrics.countArtifacts(r.res.Artifacts)
+	return res, err
+}
+
+func (e *Engine) execute(ctx context.Context, r *Request) (*Result, error) {
+	r.engine = e
+	r.start = time.Now()
+	if r.Trace {
+		r.tracer = &tracer{maxSteps: max(e.traceSteps, 1)}
+	}
+	var timings Timings
+	var tab session
+	if r.Timings {
+		r.res.Timings = &timings
+	}
+	// complete the timings on failure too, see TimingsError
+	defer func() {
+		timings.TotalMS = milliseconds(time.Since(r.start))
+		if n := len(timings.Blocks); n > 0 && timings.Blocks[n-1].DurationMS == 0 {
+			bt := timings.Blocks[n-1]
+			bt.DurationMS = timings.TotalMS - bt.StartMS
+		}
+	}()
+
+	if r.newTab() {
+		window, err := e.loadWindow(ctx, r.SessionID, r.timeout)
+		if err != nil {
+			return nil, err
+		}
+		r.SessionID = window.id
+		timings.WindowCreateMS = milliseconds(window.launch)
+		tab, err = e.createSiblingTabWithTimeout(ctx, window, r.timeout)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		tab, err = e.loadTab(r.oldTabID)
+		if err != nil {
+			return nil, err
+		}
+		if tab.id != r.oldTabID {
+			return nil, fmt.Errorf("tab with id \"%s\" doesn't exist", r.oldTabID)
+		}
+	}
+	r.tabID = tab.id
+	e.metrics.observeQueueWait(r.start)
+	timings.WindowWaitMS = milliseconds(time.Since(r.start)) - timings.WindowCreateMS
+	if r.ReuseWindow {
+		r.res.WindowID = r.SessionID
+	}
+	if r.ReuseTab {
+		r.res.TabID = tab.id
+		defer e.saveTab(tab)
+	} else {
+		defer tab.shutdown()
+	}
+
+	tabCtx, cancel := context.WithCancel(tab.ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	// create the tab now rather than in the first action, so it is timed
+	// separately
+	tabStart := time.Now()
+	if err := chromedp.Run(tabCtx); err != nil {
+		return nil, err
+	}
+	timings.TabCreateMS = milliseconds(time.Since(tabStart))
+	if r.tracer != nil {
+		r.tracer.listen(tabCtx)
+	}
+
+	var err error
+	var block *QueryBlock
+	for r.pos, block = range r.Query {
+		r.progress.Store(int32(r.pos + 1))
+
+		r.logger().Info("executing query block", "block", r.pos+1, "blocks", len(r.Query))
+
+		bt := &BlockTiming{Block: r.pos, StartMS: milliseconds(time.Since(r.start)), Actions: []*ActionTiming{}}
+		timings.Blocks = append(timings.Blocks, bt)
+		for r.iteration = 0; r.iteration < *block.Repeat; r.iteration++ {
+			err = block.cdpWhile.Do(tabCtx)
+			if err != nil {
+				return nil, err
+			}
+			if !block.cont {
+				break
+			}
+			bt.Iterations++
+			ev := Event{Type: EventBlockStart, Block: r.pos, Iteration: r.iteration}
+			r.emit(ev)
+			start := time.Now()
+			err = chromedp.Run(tabCtx, block.cdpActions...)
+			ev.Type, ev.Time = EventBlockEnd, time.Time{}
+			r.emitEnd(ev, start, err)
+			e.metrics.observeBlock(start, err)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bt.DurationMS = milliseconds(time.Since(r.start)) - bt.StartMS
+	}
+
+	return &r.res, nil
+}
+
+// EngineStats is a snapshot of the state of an engine, see Engine.Stats.
+type EngineStats struct {
+	// Allocator is "default" for headless browsers with the chromedp
+	// defaults and "custom" for browsers configured by WithAllocatorOptions.
+	Allocator string    `json:"allocator"`
+	Started   time.Time `json:"started"`
+
+	Windows         int `json:"windows"`
+	KeptTabs        int `json:"kept_tabs"`
+	Executing       int `json:"executing"`
+	InteractiveTabs int `json:"interactive_tabs"`
+}
+
+// Stats returns the number of open windows and tabs of the engine. The
+// counts are zero once the engine is closed.
+func (e *Engine) Stats() EngineStats {
+	e.Start()
+	stats := EngineStats{
+		Allocator:       "default",
+		Started:         e.started,
+		Executing:       int(e.executing.Load()),
+		InteractiveTabs: int(e.interactive.Load()),
+	}
+	if e.execAllocator {
+		stats.Allocator = "custom"
+	}
+	reply := make(chan EngineStats, 1)
+	select {
+	case e.statsQuery <- reply:
+		counts := <-reply
+		stats.Windows, stats.KeptTabs = counts.Windows, counts.KeptTabs
+	case <-e.ctx.Done():
+	}
+	return stats
+}
+
+// loadWindow returns the window session id, creating it if needed, and
+// stops waiting for it when ctx is done.
+func (e *Engine) loadWindow(ctx context.Context, id string, timeout time.Duration) (session, error) {
+	q := windowQuery{id: id, timeout: timeout, reply: make(chan session, 1)}
+	select {
+	case e.windowQuery <- q:
+	case <-ctx.Done():
+		return session{}, ctx.Err()
+	case <-e.ctx.Done():
+		return session{}, ErrEngineClosed
+	}
+	var w session
+	select {
+	case w = <-q.reply:
+	case <-ctx.Done():
+		return session{}, ctx.Err()
+	case <-e.ctx.Done():
+		return session{}, ErrEngineClosed
+	}
+	if w.id == "" {
+		return w, ErrTooManyWindows
+	}
+	return w, nil
+}
+
+// pinWindow keeps the window open, however long it goes unused, until it is
+// unpinned as many times as it was pinned.
+func (e *Engine) pinWindow(id string, delta int) {
+	select {
+	case e.windowPin <- windowPin{id, delta}:
+	case <-e.ctx.Done():
+	}
+}
+
+func (e *Engine) loadTab(id string) (session, error) {
+	q := tabLoadQuery{id: id, reply: make(chan session, 1)}
+	select {
+	case e.tabLoadQuery <- q:
+	case <-e.ctx.Done():
+		return session{}, ErrEngineClosed
+	}
+	select {
+	case tab := <-q.reply:
+		return tab, nil
+	case <-e.ctx.Done():
+		return session{}, ErrEngineClosed
+	}
+}
+
+func (e *Engine) saveTab(ses session) {
+	select {
+	case e.tabSave <- ses:
+	case <-e.ctx.Done():
+		ses.shutdown()
+	}
+}
+
+func (e *Engine) createSiblingTabWithTimeout(ctx context.Context, ses session, timeout time.Duration) (session, error) {
+	if timeout > ses.timeout {
+		var err error
+		if ses, err = e.loadWindow(ctx, ses.id, timeout); err != nil {
+			return ses, err
+		}
+	}
+	return ses.createSiblingTab(timeout), nil
+}
+
+func (e *Engine) run() {
+	defer close(e.stopped)
+	GCInterval := time.NewTicker(windowGCInterval)
+	defer GCInterval.Stop()
+
+	windows := make(map[string]session)
+	tabs := make(map[string]session)
+
+	for {
+		select {
+		case q := <-e.windowQuery:
+			w, ok := windows[q.id]
+			if !ok && q.id != e.probeWindowID && e.maxWindows > 0 {
+				n := len(windows)
+				if _, ok := windows[e.probeWindowID]; ok {
+					n--
+				}
+				if n >= e.maxWindows {
+					q.reply <- session{}
+					break
+				}
+			}
+			if !ok {
+				launchStart := time.Now()
+				w = e.createWindow(q.id)
+				w.timeout = e.windowTimeout
+				w.launch = time.Since(launchStart)
+			}
+			if q.timeout > w.timeout {
+				w.timeout = q.timeout
+			}
+			w.last = time.Now()
+			q.reply <- w
+			w.launch = 0
+			windows[w.id] = w
+
+		case t := <-e.tabSave:
+			tabs[t.id] = t
+
+		case p := <-e.windowPin:
+			if w, ok := windows[p.id]; ok {
+				w.pins += p.delta
+				w.last = time.Now()
+				windows[p.id] = w
+			}
+
+		case q := <-e.tabLoadQuery:
+			id := q.id
+			q.reply <- tabs[id]
+			delete(tabs, id)
+
+			prefix, _, err := parseTabID(id)
+			if err != nil {
+				e.logger.Warn("tab ID parse error", "error", err)
+				break
+			}
+			if w, ok := windows[prefix]; ok {
+				w.last = time.Now()
+				windows[prefix] = w
+			} else {
+				e.logger.Warn("tab ID didn't match any window", "tab", id)
+			}
+
+		case reply := <-e.statsQuery:
+			reply <- EngineStats{Windows: len(windows), KeptTabs: len(tabs)}
+
+		case <-GCInterval.C:
+			for _, w := range windows {
+				if elapsed := time.Since(w.last); w.pins == 0 && elapsed > w.timeout {
+					w.shutdown()
+					e.metrics.countEviction()
+					removed := removeWindow(w.id, &windows, &tabs)
+					e.logger.Info("closed idle window", "session", w.id,
+						"idle", elapsed.Round(100*time.Millisecond), "kept_tabs", removed)
+				}
+			}
+
+		case <-e.ctx.Done():
+			for _, w := range windows {
+				w.shutdown()
+			}
+			return
+		}
+	}
+}
+
+func (e *Engine) createWindow(id string) session {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if e.execAllocator {
+		ctx, cancel = chromedp.NewExecAllocator(e.ctx, e.allocatorOpts...)
+	} else {
+		ctx, cancel = chromedp.NewContext(e.ctx)
+	}
+	e.metrics.countLaunch()
+	var w session
+	w.cancel = cancel
+	if len(id) < 8 {
+		w.id = createSessionID()
+	} else {
+		w.id = id
+	}
+
+	// create a persistent dummy tab to keep the window open
+	w.ctx, _ = chromedp.NewContext(ctx)
+	chromedp.Run(w.ctx, chromedp.Navigate("about:blank"))
+
+	return w
+}
+
+var (
+	defaultEngine     *Engine
+	defaultEngineOnce sync.Once
+)
+
+// DefaultEngine returns the engine used by Request.Execute, OpenTab and
+// AllocateSessions, starting it on first use. Its browser is visible if the
+// environment variable DEBUG is "true".
+func DefaultEngine() *Engine {
+	defaultEngineOnce.Do(func() {
+		var opts []EngineOption
+		if os.Getenv("DEBUG") == "true" {
+			opts = append(opts, WithAllocatorOptions())
+		}
+		defaultEngine = NewEngine(opts...)
+		defaultEngine.Start()
+	})
+	return defaultEngine
+}
+
+// AllocateSessions runs the default engine until it is closed.
+//
+// Deprecated: The default engine is started on first use. Programs wanting
+// control over its lifetime should use NewEngine.
+func AllocateSessions() {
+	<-DefaultEngine().stopped
+}
@@ -0,0 +1,84 @@
+package decap
+
+import (
+	"context"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	EventBlockStart  = "block_start"
+	EventBlockEnd    = "block_end"
+	EventActionStart = "action_start"
+	EventActionEnd   = "action_end"
+)
+
+// Event reports the progress of an executing request to the function set by
+// Request.OnEvent. Action is nil for block events, and Iteration counts the
+// repeats of a block from zero. Out holds the outputs added by an action.
+type Event struct {
+	Type       string    `json:"type"`
+	Block      int       `json:"block"`
+	Action     *int      `json:"action,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Iteration  int       `json:"iteration"`
+	Time       time.Time `json:"time"`
+	DurationMS *float64  `json:"duration_ms,omitempty"`
+	Out        []string  `json:"out,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (r *Request) emit(ev Event) {
+	if r.OnEvent == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	r.OnEvent(ev)
+}
+
+func (r *Request) emitEnd(ev Event, start time.Time, err error) {
+	ms := float64(time.Since(start).Microseconds()) / 1000
+	ev.DurationMS = &ms
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	r.emit(ev)
+}
+
+// observeAction wraps the chromedp actions making up a single query action,
+// emitting start and end events around them and recording their duration.
+func (r *Request) observeAction(blockPos, actionPos int, actions []chromedp.Action) chromedp.Action {
+	name := r.Query[blockPos].Actions[actionPos].Name()
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		start := time.Now()
+		if r.OnEvent == nil {
+			err := chromedp.Tasks(actions).Do(ctx)
+			r.metrics().observeAction(name, start, err)
+			r.timeAction(actionPos, name, start, err)
+			r.traceAction(ctx, actionPos, name, start, err)
+			return err
+		}
+		ev := Event{
+			Type:      EventActionStart,
+			Block:     blockPos,
+			Action:    &actionPos,
+			Name:      name,
+			Iteration: r.iteration,
+		}
+		r.emit(ev)
+		outs := len(r.res.Out[blockPos])
+
+		err := chromedp.Tasks(actions).Do(ctx)
+		r.metrics().observeAction(name, start, err)
+		r.timeAction(actionPos, name, start, err)
+		r.traceAction(ctx, actionPos, name, start, err)
+
+		ev.Type, ev.Time = EventActionEnd, time.Time{}
+		ev.Out = r.res.Out[blockPos][outs:]
+		r.emitEnd(ev, start, err)
+		return err
+	})
+}
@@ -0,0 +1,49 @@
+package decap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// Probe checks that the engine can render by opening a tab and evaluating
+// 1+1 in it, and returns the browser version (e.g. "HeadlessChrome/140.0").
+// The tab is opened in a window of its own, which is kept open like other
+// windows, so frequent probes are cheap, and which doesn't count toward
+// WithMaxWindows.
+func (e *Engine) Probe(ctx context.Context) (version string, err error) {
+	e.Start()
+	window, err := e.loadWindow(ctx, e.probeWindowID, 0)
+	if err != nil {
+		return "", err
+	}
+	tab := window.createSiblingTab(e.windowTimeout)
+	defer tab.shutdown()
+
+	tabCtx, cancel := context.WithCancel(tab.ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	var sum int
+	err = chromedp.Run(tabCtx,
+		chromedp.Evaluate("1+1", &sum),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			_, version, _, _, _, err = browser.GetVersion().Do(ctx)
+			return err
+		}),
+	)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+	if sum != 2 {
+		return "", fmt.Errorf("evaluating 1+1 gave %d", sum)
+	}
+	return version, nil
+}
@@ -0,0 +1,152 @@
+package decap
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	DefaultNetworkQuiet = 500 * time.Millisecond
+
+	// networkIdleEvent is the listen event fired by decap's own idle
+	// detector, as opposed to Chrome's networkIdle lifecycle event.
+	networkIdleEvent = "decapNetworkIdle"
+)
+
+type idleOptions struct {
+	maxInflight int
+	quiet       time.Duration
+	ignore      []*regexp.Regexp
+}
+
+func defaultIdleOptions() idleOptions {
+	return idleOptions{quiet: DefaultNetworkQuiet}
+}
+
+func (o idleOptions) ignored(url string) bool {
+	for _, re := range o.ignore {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkTracker follows the requests of a tab from when the Network domain
+// is enabled, so that a wait for network idle also sees the requests which
+// were already in flight when it began, such as the document request of a
+// preceding navigate.
+type networkTracker struct {
+	mu       sync.Mutex
+	started  bool
+	inflight map[network.RequestID]string // URLs by request
+}
+
+// listen tracks the requests of the tab until ctx is done. Calling it again
+// has no effect.
+func (t *networkTracker) listen(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started {
+		return
+	}
+	t.started = true
+	t.inflight = make(map[network.RequestID]string)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			t.inflight[e.RequestID] = e.Request.URL
+		case *network.EventLoadingFinished:
+			delete(t.inflight, e.RequestID)
+		case *network.EventLoadingFailed:
+			delete(t.inflight, e.RequestID)
+		}
+	})
+}
+
+// inFlight returns the IDs of the requests in flight, leaving out ignored
+// ones.
+func (t *networkTracker) inFlight(opts idleOptions) []network.RequestID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ids []network.RequestID
+	for id, url := range t.inflight {
+		if !opts.ignored(url) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// enableNetworkTracking enables the Network domain and starts tracking the
+// requests of the tab.
+func enableNetworkTracking(t *networkTracker) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		if err := network.Enable().Do(ctx); err != nil {
+			return err
+		}
+		t.listen(ctx)
+		return nil
+	}
+}
+
+// waitNetworkIdle waits until at most maxInflight requests (not counting
+// ignored ones) have been in flight for the quiet period. Requests already
+// in flight when the wait begins are taken from the tracker, which only
+// knows them if it was started.
+func waitNetworkIdle(t *networkTracker, opts idleOptions, interval time.Duration) waitCondition {
+	return func(ctx context.Context) error {
+		var mu sync.Mutex
+		inflight := make(map[network.RequestID]bool)
+		var idleSince time.Time
+
+		update := func() {
+			if len(inflight) > opts.maxInflight {
+				idleSince = time.Time{}
+			} else if idleSince.IsZero() {
+				idleSince = time.Now()
+			}
+		}
+
+		lctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		chromedp.ListenTarget(lctx, func(ev interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch e := ev.(type) {
+			case *network.EventRequestWillBeSent:
+				if !opts.ignored(e.Request.URL) {
+					inflight[e.RequestID] = true
+				}
+			case *network.EventLoadingFinished:
+				delete(inflight, e.RequestID)
+			case *network.EventLoadingFailed:
+				delete(inflight, e.RequestID)
+			default:
+				return
+			}
+			update()
+		})
+
+		// seed after listening, so no request falls between the two
+		mu.Lock()
+		for _, id := range t.inFlight(opts) {
+			inflight[id] = true
+		}
+		update()
+		mu.Unlock()
+
+		return pollUntil(interval, func(ctx context.Context) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return !idleSince.IsZero() && time.Since(idleSince) >= opts.quiet, nil
+		})(ctx)
+	}
+}
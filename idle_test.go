@@ -0,0 +1,70 @@
+package decap
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestParseNetworkIdle(t *testing.T) {
+	tests := []struct {
+		block string
+		err   string // substring of the error, "" if valid
+	}{
+		{`null`, ""},
+		{`{"max_inflight": 2, "quiet": "1s", "ignore": ["\\.mp4$", "analytics"]}`, ""},
+		{`{"max_inflight": -1}`, "network_idle.max_inflight: negative value"},
+		{`{"quiet": "soon"}`, "network_idle.quiet: invalid duration"},
+		{`{"quiet": "-1s"}`, "network_idle.quiet: negative value"},
+		{`{"ignore": ["ok", "("]}`, "network_idle.ignore[1]"},
+	}
+	for _, test := range tests {
+		body := `{"global_render_delay": "0s", "network_idle": ` + test.block +
+			`, "query": [{"actions": [["navigate", "http://example.com"], ["outer_html"]]}]}`
+		r := new(Request)
+		err := r.ParseRequest(strings.NewReader(body))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", test.block, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want it to contain %q", test.block, err, test.err)
+		}
+	}
+
+	r := new(Request)
+	body := `{"global_render_delay": "0s", "network_idle": {"max_inflight": 2, "quiet": "1s", "ignore": ["\\.mp4$"]},
+		"query": [{"actions": [["navigate", "http://example.com"], ["outer_html"]]}]}`
+	if err := r.ParseRequest(strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	if o := r.networkIdle; o.maxInflight != 2 || o.quiet != time.Second || len(o.ignore) != 1 {
+		t.Errorf("got options %+v", o)
+	}
+	if o := defaultIdleOptions(); o.quiet != DefaultNetworkQuiet || o.maxInflight != 0 {
+		t.Errorf("got default options %+v", o)
+	}
+}
+
+func TestNetworkTrackerInFlight(t *testing.T) {
+	tr := &networkTracker{inflight: map[network.RequestID]string{
+		"1": "https://example.com/",
+		"2": "https://example.com/video.mp4",
+		"3": "https://analytics.example.com/collect",
+	}}
+	opts := idleOptions{ignore: []*regexp.Regexp{
+		regexp.MustCompile(`\.mp4$`),
+		regexp.MustCompile(`^https://analytics\.`),
+	}}
+	if got := tr.inFlight(opts); !slices.Equal(got, []network.RequestID{"1"}) {
+		t.Errorf("got in flight %v, want [1]", got)
+	}
+	ids := tr.inFlight(idleOptions{})
+	slices.Sort(ids)
+	if !slices.Equal(ids, []network.RequestID{"1", "2", "3"}) {
+		t.Errorf("without ignore patterns: got %v", ids)
+	}
+}
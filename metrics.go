@@ -0,0 +1,275 @@
+package decap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds in seconds of the duration
+// histograms, spanning fast DOM actions to slow page loads.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// engineMetrics are the counters and histograms of an engine, written in
+// the Prometheus text format by Engine.WriteMetrics. Its methods may be
+// called on a nil pointer, which records nothing.
+type engineMetrics struct {
+	requests      *metric
+	actions       *metric
+	blocks        *metric
+	queueWait     *metric
+	evictions     *metric
+	launches      *metric
+	artifactBytes *metric
+	listenEvents  *metric
+}
+
+func newEngineMetrics() *engineMetrics {
+	return &engineMetrics{
+		requests: newHistogram("decap_request_duration_seconds",
+			"Duration of executed requests by outcome.", "outcome"),
+		actions: newHistogram("decap_action_duration_seconds",
+			"Duration of executed actions by action name and outcome.", "action", "outcome"),
+		blocks: newHistogram("decap_block_duration_seconds",
+			"Duration of each run of a query block by outcome.", "outcome"),
+		queueWait: newHistogram("decap_queue_wait_seconds",
+			"Time requests waited for a window and tab before executing."),
+		evictions: newCounter("decap_window_evictions_total",
+			"Windows closed after being unused for longer than their timeout."),
+		launches: newCounter("decap_browser_launches_total",
+			"Browsers launched, one per window."),
+		artifactBytes: newCounter("decap_artifact_bytes_total",
+			"Bytes of artifacts produced by type (pdf, png, jpeg etc.).", "type"),
+		listenEvents: newCounter("decap_listen_events_total",
+			"Lifecycle events seen by listen, by event and whether they were caught or ignored.",
+			"event", "result"),
+	}
+}
+
+// outcome labels an error as "ok", "timeout", "canceled" or "error".
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "error"
+}
+
+func (m *engineMetrics) observeRequest(start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.requests.observe(time.Since(start).Seconds(), outcome(err))
+}
+
+func (m *engineMetrics) countArtifacts(artifacts []*Artifact) {
+	if m == nil {
+		return
+	}
+	for _, a := range artifacts {
+		m.artifactBytes.add(float64(len(a.Data)), a.Type)
+	}
+}
+
+func (m *engineMetrics) observeAction(name string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.actions.observe(time.Since(start).Seconds(), name, outcome(err))
+}
+
+func (m *engineMetrics) observeBlock(start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.blocks.observe(time.Since(start).Seconds(), outcome(err))
+}
+
+func (m *engineMetrics) observeQueueWait(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.queueWait.observe(time.Since(start).Seconds())
+}
+
+func (m *engineMetrics) countEviction() {
+	if m == nil {
+		return
+	}
+	m.evictions.add(1)
+}
+
+func (m *engineMetrics) countLaunch() {
+	if m == nil {
+		return
+	}
+	m.launches.add(1)
+}
+
+func (m *engineMetrics) countListenEvent(event string, caught bool) {
+	if m == nil {
+		return
+	}
+	result := "ignored"
+	if caught {
+		result = "caught"
+	}
+	m.listenEvents.add(1, event, result)
+}
+
+// WriteMetrics writes the metrics of the engine in the Prometheus text
+// exposition format.
+func (e *Engine) WriteMetrics(w io.Writer) error {
+	var buf bytes.Buffer
+	stats := e.Stats()
+	gauges := []struct {
+		name, help string
+		value      int
+	}{
+		{"decap_windows", "Open browser windows.", stats.Windows},
+		{"decap_kept_tabs", "Tabs kept open by reuse_tab.", stats.KeptTabs},
+		{"decap_executing_requests", "Requests currently executing.", stats.Executing},
+		{"decap_interactive_tabs", "Open interactive tabs.", stats.InteractiveTabs},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.value)
+	}
+	if !stats.Started.IsZero() {
+		fmt.Fprintf(&buf, "# HELP decap_start_time_seconds Start time of the engine since the epoch.\n"+
+			"# TYPE decap_start_time_seconds gauge\ndecap_start_time_seconds %d\n", stats.Started.Unix())
+	}
+	m := e.metrics
+	for _, metric := range []*metric{
+		m.requests, m.actions, m.blocks, m.queueWait,
+		m.evictions, m.launches, m.artifactBytes, m.listenEvents,
+	} {
+		metric.write(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// metric is a counter or histogram with a series per combination of label
+// values.
+type metric struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // nil for counters
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64  // the counter value, or the sum of a histogram
+	counts []uint64 // observations per bucket, not cumulative
+	count  uint64
+}
+
+func newCounter(name, help string, labels ...string) *metric {
+	m := &metric{name: name, help: help, labels: labels, series: make(map[string]*series)}
+	if len(labels) == 0 {
+		m.get(nil) // report zero rather than nothing
+	}
+	return m
+}
+
+func newHistogram(name, help string, labels ...string) *metric {
+	m := &metric{name: name, help: help, labels: labels, buckets: durationBuckets,
+		series: make(map[string]*series)}
+	if len(labels) == 0 {
+		m.get(nil)
+	}
+	return m
+}
+
+// get returns the series of the label values, which must be locked.
+func (m *metric) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: values}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(values).value += v
+}
+
+func (m *metric) observe(v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(values)
+	s.value += v
+	s.count++
+	if i, _ := slices.BinarySearch(m.buckets, v); i < len(m.buckets) {
+		s.counts[i]++
+	}
+}
+
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	typ := "counter"
+	if m.buckets != nil {
+		typ = "histogram"
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, typ)
+	leLabels := slices.Concat(m.labels, []string{"le"})
+	for _, key := range slices.Sorted(maps.Keys(m.series)) {
+		s := m.series[key]
+		labels := formatLabels(m.labels, s.labels)
+		if m.buckets == nil {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels, formatValue(s.value))
+			continue
+		}
+		bucket := func(le string) string {
+			return formatLabels(leLabels, slices.Concat(s.labels, []string{le}))
+		}
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, bucket(formatValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, bucket("+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labels, formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labels, s.count)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
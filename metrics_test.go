@@ -0,0 +1,96 @@
+package decap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMetricWrite(t *testing.T) {
+	counter := newCounter("test_total", "Things counted.", "type", "note")
+	counter.add(1, "png", "plain")
+	counter.add(2.5, "png", "plain")
+	counter.add(1, "pdf", "say \"hi\"\\\n")
+
+	var b strings.Builder
+	counter.write(&b)
+	want := `# HELP test_total Things counted.
+# TYPE test_total counter
+test_total{type="pdf",note="say \"hi\"\\\n"} 1
+test_total{type="png",note="plain"} 3.5
+`
+	if got := b.String(); got != want {
+		t.Errorf("counter: got\n%s\nwant\n%s", got, want)
+	}
+
+	b.Reset()
+	newCounter("test_unlabeled_total", "Nothing yet.").write(&b)
+	want = `# HELP test_unlabeled_total Nothing yet.
+# TYPE test_unlabeled_total counter
+test_unlabeled_total 0
+`
+	if got := b.String(); got != want {
+		t.Errorf("unlabeled counter: got\n%s\nwant\n%s", got, want)
+	}
+
+	histogram := newHistogram("test_seconds", "Durations.", "outcome")
+	histogram.buckets = []float64{0.1, 1}
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		histogram.observe(v, "ok")
+	}
+	b.Reset()
+	histogram.write(&b)
+	want = `# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{outcome="ok",le="0.1"} 2
+test_seconds_bucket{outcome="ok",le="1"} 3
+test_seconds_bucket{outcome="ok",le="+Inf"} 4
+test_seconds_sum{outcome="ok"} 3.65
+test_seconds_count{outcome="ok"} 4
+`
+	if got := b.String(); got != want {
+		t.Errorf("histogram: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{fmt.Errorf("navigate: %w", context.DeadlineExceeded), "timeout"},
+		{context.Canceled, "canceled"},
+		{errors.New("no such element"), "error"},
+	}
+	for _, test := range tests {
+		if got := outcome(test.err); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	e := NewEngine()
+	defer e.Close()
+	e.metrics.countArtifacts([]*Artifact{{Type: "png", Data: make([]byte, 10)}})
+	e.metrics.countListenEvent("load", true)
+
+	var b strings.Builder
+	if err := e.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE decap_windows gauge\ndecap_windows 0\n",
+		"# TYPE decap_request_duration_seconds histogram\n",
+		"decap_queue_wait_seconds_count 0\n",
+		`decap_artifact_bytes_total{type="png"} 10` + "\n",
+		`decap_listen_events_total{event="load",result="caught"} 1` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("no %q in metrics:\n%s", want, b.String())
+		}
+	}
+}
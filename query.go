@@ -1,25 +1,46 @@
 package decap
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/url"
-	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
 )
 
 const (
 	MaxRenderDelay = 10 * time.Second
 	MaxTimeout     = 120 * time.Second
+
+	DefaultScrollInterval      = 250 * time.Millisecond
+	DefaultScrollStable        = time.Second
+	DefaultScrollMaxHeight     = 50000
+	DefaultScrollMaxIterations = 200
 )
 
 var (
+	artifactNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	pageRangesRegexp   = regexp.MustCompile(`^\s*\d*(-\d*)?(\s*,\s*\d*(-\d*)?)*\s*$`)
+
+	// paper sizes in inches (width, height)
+	paperSizes = map[string][2]float64{
+		"a3":      {11.69, 16.54},
+		"a4":      {8.27, 11.69},
+		"a5":      {5.83, 8.27},
+		"legal":   {8.5, 14},
+		"letter":  {8.5, 11},
+		"tabloid": {11, 17},
+	}
+
 	DefaultPageloadEvents = []string{
 		"DOMContentLoaded",
 		"firstMeaningfulPaint",
@@ -29,31 +50,127 @@ var (
 )
 
 type Result struct {
-	Err      []string   `json:"err"`
-	Out      [][]string `json:"out"`
-	TabID    string     `json:"tab_id"`
-	WindowID string     `json:"window_id"`
-	img      []byte
-	pdf      []byte
+	Err       []string    `json:"err"`
+	Out       [][]string  `json:"out"`
+	TabID     string      `json:"tab_id"`
+	WindowID  string      `json:"window_id"`
+	Artifacts []*Artifact `json:"artifacts,omitempty"`
+	Timings   *Timings    `json:"timings,omitempty"`
 }
 
+// Type returns the type of the sole artifact if there is exactly one, and
+// "json" otherwise.
 func (res *Result) Type() string {
-	switch {
-	case len(res.pdf) != 0:
+	if len(res.Artifacts) == 1 {
+		return res.Artifacts[0].Type
+	}
+	return "json"
+}
+
+func (res *Result) ImgBuffer() []byte {
+	for i := len(res.Artifacts) - 1; i >= 0; i-- {
+		if res.Artifacts[i].Type != "pdf" {
+			return res.Artifacts[i].Data
+		}
+	}
+	return nil
+}
+
+func (res *Result) PDFBuffer() []byte {
+	for i := len(res.Artifacts) - 1; i >= 0; i-- {
+		if res.Artifacts[i].Type == "pdf" {
+			return res.Artifacts[i].Data
+		}
+	}
+	return nil
+}
+
+// addArtifact appends a new artifact, suffixing the name with a counter if
+// an action is repeated.
+func (res *Result) addArtifact(name, typ string, data []byte) {
+	unique := name
+	for n := 2; res.Artifact(unique) != nil; n++ {
+		unique = fmt.Sprintf("%s_%d", name, n)
+	}
+	res.Artifacts = append(res.Artifacts, &Artifact{Name: unique, Type: typ, Data: data})
+}
+
+// Artifact returns the artifact with the given name, or nil.
+func (res *Result) Artifact(name string) *Artifact {
+	for _, a := range res.Artifacts {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// Artifact is a binary output of a screenshot or print_to_pdf action. Data
+// is base64 encoded in JSON.
+type Artifact struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// FormatFromMediaType maps a media type (or a format name) to one of the
+// response formats json, multipart, zip, pdf, png, jpeg and webp. It returns
+// "" for anything else.
+func FormatFromMediaType(mediaType string) string {
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "json", "application/json":
+		return "json"
+	case "multipart", "multipart/mixed":
+		return "multipart"
+	case "zip", "application/zip":
+		return "zip"
+	case "pdf", "application/pdf":
 		return "pdf"
-	case len(res.img) != 0:
+	case "png", "image/png":
 		return "png"
+	case "jpeg", "jpg", "image/jpeg":
+		return "jpeg"
+	case "webp", "image/webp":
+		return "webp"
 	default:
-		return "json"
+		return ""
 	}
 }
 
-func (res *Result) ImgBuffer() []byte {
-	return res.img
+// ArtifactsOfType returns the artifacts of the given type (e.g. "png").
+func (res *Result) ArtifactsOfType(typ string) []*Artifact {
+	var artifacts []*Artifact
+	for _, a := range res.Artifacts {
+		if a.Type == typ {
+			artifacts = append(artifacts, a)
+		}
+	}
+	return artifacts
 }
 
-func (res *Result) PDFBuffer() []byte {
-	return res.pdf
+// MediaType maps a response format to its media type.
+func MediaType(format string) string {
+	switch format {
+	case "json", "pdf", "zip":
+		return "application/" + format
+	case "multipart":
+		return "multipart/mixed"
+	case "jpeg", "png", "webp":
+		return "image/" + format
+	default:
+		return ""
+	}
+}
+
+func (a *Artifact) ContentType() string {
+	return MediaType(a.Type)
+}
+
+func (a *Artifact) Filename() string {
+	if a.Type == "jpeg" {
+		return a.Name + ".jpg"
+	}
+	return a.Name + "." + a.Type
 }
 
 type QueryBlock struct {
@@ -66,6 +183,12 @@ type QueryBlock struct {
 	pos        int
 }
 
+type NetworkIdleBlock struct {
+	MaxInflight int      `json:"max_inflight"`
+	Quiet       string   `json:"quiet"`
+	Ignore      []string `json:"ignore"`
+}
+
 type ViewportBlock struct {
 	Width       int      `json:"width"`
 	Height      int      `json:"height"`
@@ -75,67 +198,78 @@ type ViewportBlock struct {
 }
 
 type Request struct {
-	Query            []*QueryBlock  `json:"query"`
-	EmulateViewport  *ViewportBlock `json:"emulate_viewport"`
-	ForwardUserAgent bool           `json:"forward_user_agent"`
-	RenderDelay      string         `json:"global_render_delay"`
-	ReuseTab         bool           `json:"reuse_tab"`
-	ReuseWindow      bool           `json:"reuse_window"`
-	SessionID        string         `json:"sessionid"`
-	Timeout          string         `json:"timeout"`
+	Query            []*QueryBlock     `json:"query"`
+	CallbackURL      string            `json:"callback_url"`
+	EmulateViewport  *ViewportBlock    `json:"emulate_viewport"`
+	ForwardUserAgent bool              `json:"forward_user_agent"`
+	NetworkIdle      *NetworkIdleBlock `json:"network_idle"`
+	RenderDelay      string            `json:"global_render_delay"`
+	ResponseFormat   string            `json:"response_format"`
+	ReuseTab         bool              `json:"reuse_tab"`
+	ReuseWindow      bool              `json:"reuse_window"`
+	SessionID        string            `json:"sessionid"`
+	Timeout          string            `json:"timeout"`
+	Timings          bool              `json:"timings"`
+	Trace            bool              `json:"trace"`
+	OnEvent          func(Event)       `json:"-"`
+	ID               string            `json:"-"` // identifies the request in logs
+	artifactNames    map[string]bool
+	engine           *Engine
+	networkIdle      idleOptions
+	netTracker       networkTracker
+	iteration        int
 	oldTabID         string
 	pos              int
+	progress         atomic.Int32
 	renderDelay      time.Duration
 	res              Result
+	start            time.Time
+	tabID            string
 	timeout          time.Duration
+	tracer           *tracer
 }
 
+// Execute executes the request with the default engine.
 func (r *Request) Execute() (*Result, error) {
-	var tab session
+	return r.ExecuteContext(context.Background())
+}
 
-	if r.newTab() {
-		window := loadWindow(r.SessionID, r.timeout)
-		r.SessionID = window.id
-		tab = window.createSiblingTabWithTimeout(r.timeout)
-	} else {
-		tab = loadTab(r.oldTabID)
-		if tab.id != r.oldTabID {
-			return nil, fmt.Errorf("tab with id \"%s\" doesn't exist", r.oldTabID)
-		}
+// ExecuteContext is like Execute but stops running actions when ctx is done.
+func (r *Request) ExecuteContext(ctx context.Context) (*Result, error) {
+	return DefaultEngine().Execute(ctx, r)
+}
+
+// metrics returns the metrics of the engine executing the request, if any.
+func (r *Request) metrics() *engineMetrics {
+	if r.engine == nil {
+		return nil
 	}
-	if r.ReuseWindow {
-		r.res.WindowID = r.SessionID
+	return r.engine.metrics
+}
+
+// logger returns the logger of the engine executing the request, with the
+// request, window session and tab IDs as attributes.
+func (r *Request) logger() *slog.Logger {
+	l := slog.Default()
+	if r.engine != nil {
+		l = r.engine.logger
 	}
-	if r.ReuseTab {
-		r.res.TabID = tab.id
-		defer tab.saveTab()
-	} else {
-		defer tab.shutdown()
+	if r.ID != "" {
+		l = l.With("request_id", r.ID)
 	}
-
-	var err error
-	var block *QueryBlock
-	for r.pos, block = range r.Query {
-
-		fmt.Fprintf(os.Stderr, "%s Query %d/%d (session %s)\n",
-			time.Now().Format("[15:04:05]"), r.pos+1, len(r.Query), r.SessionID)
-
-		for i := 0; i < *block.Repeat; i++ {
-			err = block.cdpWhile.Do(tab.ctx)
-			if err != nil {
-				return nil, err
-			}
-			if !block.cont {
-				break
-			}
-			err = chromedp.Run(tab.ctx, block.cdpActions...)
-			if err != nil {
-				return nil, err
-			}
-		}
+	if r.SessionID != "" {
+		l = l.With("session", r.SessionID)
 	}
+	if r.tabID != "" {
+		l = l.With("tab", r.tabID)
+	}
+	return l
+}
 
-	return &r.res, nil
+// Progress returns the number of query blocks started so far and the total
+// number of blocks. It is safe to call while the request is executing.
+func (r *Request) Progress() (started, total int) {
+	return int(r.progress.Load()), len(r.Query)
 }
 
 func (r *Request) ParseRequest(body io.Reader) error {
@@ -143,26 +277,56 @@ func (r *Request) ParseRequest(body io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("JSON parsing error: %s", err)
 	}
-	if r.ForwardUserAgent {
-		// TODO: Implement user agent forwarding in execute()
-		return fmt.Errorf("value \"true\" is not supported for init.forward_user_agent")
+	if len(r.Query) == 0 {
+		return fmt.Errorf("query[0] must contain at least one action block")
 	}
+	report := stopAtFirst(&err)
+	if r.parseSettings(report) {
+		r.parseQueryBlocks(report)
+	}
+	return err
+}
 
-	err = r.parseEmulateViewport()
-	if err != nil {
-		return err
+// parseReporter receives each parse error along with the JSON path of the
+// offending field. Parsing stops unless it returns true.
+type parseReporter func(path string, err error) bool
+
+// stopAtFirst returns a parseReporter which stores the first error in err.
+func stopAtFirst(err *error) parseReporter {
+	return func(_ string, e error) bool {
+		*err = e
+		return false
 	}
-	err = r.parseRenderDelay()
-	if err != nil {
-		return err
+}
+
+// parseSettings parses everything but the query blocks. Setup actions such
+// as viewport emulation are appended to the first query block. It returns
+// false if parsing was stopped by report.
+func (r *Request) parseSettings(report parseReporter) bool {
+	settings := []struct {
+		path  string
+		parse func() error
+	}{
+		{"forward_user_agent", r.parseForwardUserAgent},
+		{"emulate_viewport", r.parseEmulateViewport},
+		{"global_render_delay", r.parseRenderDelay},
+		{"timeout", r.parseTimeout},
+		{"network_idle", r.parseNetworkIdle},
+		{"response_format", r.parseResponseFormat},
+		{"callback_url", r.parseCallbackURL},
 	}
-	err = r.parseTimeout()
-	if err != nil {
-		return err
+	for _, setting := range settings {
+		if err := setting.parse(); err != nil && !report(setting.path, err) {
+			return false
+		}
 	}
-	err = r.parseQueryBlocks()
-	if err != nil {
-		return err
+	return true
+}
+
+func (r *Request) parseForwardUserAgent() error {
+	if r.ForwardUserAgent {
+		// TODO: Implement user agent forwarding in execute()
+		return fmt.Errorf("forward_user_agent: value \"true\" is not supported")
 	}
 	return nil
 }
@@ -205,11 +369,11 @@ func (r *Request) parseEmulateViewport() error {
 
 func (r *Request) parseRenderDelay() error {
 	if r.RenderDelay == "" {
-		return fmt.Errorf("global_render_delay is empty or missing")
+		return fmt.Errorf("global_render_delay: empty or missing")
 	}
 	delay, err := time.ParseDuration(r.RenderDelay)
 	if err != nil {
-		return fmt.Errorf("invalid global_render_delay: %s", err)
+		return fmt.Errorf("global_render_delay: invalid duration: %s", err)
 	}
 	if delay > MaxRenderDelay {
 		delay = MaxRenderDelay
@@ -225,7 +389,7 @@ func (r *Request) parseTimeout() error {
 	}
 	timeout, err := time.ParseDuration(r.Timeout)
 	if err != nil {
-		return fmt.Errorf("invalid timeout: %s", err)
+		return fmt.Errorf("timeout: invalid duration: %s", err)
 	}
 	if timeout > MaxTimeout {
 		timeout = MaxTimeout
@@ -234,45 +398,112 @@ func (r *Request) parseTimeout() error {
 	return nil
 }
 
-func (r *Request) parseQueryBlocks() error {
+func (r *Request) parseNetworkIdle() error {
+	r.networkIdle = defaultIdleOptions()
+	if r.NetworkIdle == nil {
+		return nil
+	}
+	if r.NetworkIdle.MaxInflight < 0 {
+		return fmt.Errorf("network_idle.max_inflight: negative value (%d) not allowed",
+			r.NetworkIdle.MaxInflight)
+	}
+	r.networkIdle.maxInflight = r.NetworkIdle.MaxInflight
+	if r.NetworkIdle.Quiet != "" {
+		quiet, err := time.ParseDuration(r.NetworkIdle.Quiet)
+		if err != nil {
+			return fmt.Errorf("network_idle.quiet: invalid duration: %s", err)
+		}
+		if quiet < 0 {
+			return fmt.Errorf("network_idle.quiet: negative value (%s) not allowed", quiet)
+		}
+		r.networkIdle.quiet = quiet
+	}
+	for i, pattern := range r.NetworkIdle.Ignore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("network_idle.ignore[%d]: %s", i, err)
+		}
+		r.networkIdle.ignore = append(r.networkIdle.ignore, re)
+	}
+	return nil
+}
+
+func (r *Request) parseResponseFormat() error {
+	if r.ResponseFormat == "" {
+		return nil
+	}
+	format := FormatFromMediaType(r.ResponseFormat)
+	if format == "" {
+		return fmt.Errorf(`response_format: unknown format "%s"`, r.ResponseFormat)
+	}
+	r.ResponseFormat = format
+	return nil
+}
+
+func (r *Request) parseCallbackURL() error {
+	if r.CallbackURL == "" {
+		return nil
+	}
+	u, err := url.ParseRequestURI(r.CallbackURL)
+	if err != nil {
+		return fmt.Errorf("callback_url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback_url: expected an absolute http(s) URL")
+	}
+	return nil
+}
+
+// parseQueryBlocks returns false if parsing was stopped by report.
+func (r *Request) parseQueryBlocks(report parseReporter) bool {
 
 	if len(r.Query) == 0 {
-		return fmt.Errorf("query[0] must contain at least one action block")
+		return report("query", fmt.Errorf("query[0] must contain at least one action block"))
 	}
 	if len(r.Query[0].Actions) < 1 {
-		return fmt.Errorf("query[0].actions must contain at least one action")
-	}
-	switch name := r.Query[0].Actions[0].Name(); name {
-	case "load_html", "navigate":
-		if len(r.Query[0].Actions) < 2 {
-			return fmt.Errorf(
-				`query[0].actions must contain at least one other action besides "%s"`,
-				name,
-			)
-		}
-	case "load_tab":
-		r.oldTabID = r.Query[0].Actions[0].Arg(1)
-		r.Query[0].Actions = r.Query[0].Actions[1:]
-		prefix, _, err := parseTabID(r.oldTabID)
-		if err != nil {
-			return fmt.Errorf("load_tab: %s", err)
-		}
-		switch r.SessionID {
-		case "":
-			r.SessionID = prefix
-			fmt.Fprintf(os.Stderr, "Loading tab %s, inferring window %s\n",
-				r.oldTabID, r.SessionID)
-		case prefix:
-			fmt.Fprintf(os.Stderr, "Loading tab %s and window %s\n", r.oldTabID, r.SessionID)
+		if !report("query[0].actions", fmt.Errorf("query[0].actions: must contain at least one action")) {
+			return false
+		}
+	} else {
+		switch name := r.Query[0].Actions[0].Name(); name {
+		case "load_html", "navigate":
+			if len(r.Query[0].Actions) < 2 {
+				err := fmt.Errorf(
+					`query[0].actions: must contain at least one other action besides "%s"`,
+					name,
+				)
+				if !report("query[0].actions", err) {
+					return false
+				}
+			}
+		case "load_tab":
+			r.oldTabID = r.Query[0].Actions[0].Arg(1)
+			r.Query[0].Actions = r.Query[0].Actions[1:]
+			prefix, _, err := parseTabID(r.oldTabID)
+			switch {
+			case err != nil:
+				if !report("query[0].actions[0]", fmt.Errorf("load_tab: %s", err)) {
+					return false
+				}
+			case r.SessionID == "":
+				r.SessionID = prefix
+			case r.SessionID == prefix:
+			default:
+				err = fmt.Errorf("tab %s is not part of window session %s", r.oldTabID, r.SessionID)
+				if !report("sessionid", err) {
+					return false
+				}
+			}
 		default:
-			return fmt.Errorf("tab %s is not part of window session %s", r.oldTabID, r.SessionID)
+			err := fmt.Errorf(`query[0].actions[0]: must begin with either "load_html", "load_tab" or "navigate"`)
+			if !report("query[0].actions[0]", err) {
+				return false
+			}
 		}
-	default:
-		return fmt.Errorf(`query[0].actions[0] must begin with either "load_html", "load_tab" or "navigate"`)
 	}
 
 	if r.hasListeningEvents() {
-		r.appendActions(network.Enable(), enableLifecycleEvents())
+		r.appendActions(enableNetworkTracking(&r.netTracker), enableLifecycleEvents())
 	}
 
 	r.res.Err = make([]string, len(r.Query))
@@ -286,36 +517,56 @@ func (r *Request) parseQueryBlocks() error {
 		// r.res.Err[r.pos] = make([]string, 0)
 		r.res.Out[r.pos] = make([]string, 0)
 
-		if len(block.Actions) == 0 && r.newTab() {
-			return fmt.Errorf("query[%d].actions can't be empty", r.pos)
+		if len(block.Actions) == 0 && r.newTab() && r.pos > 0 {
+			path := fmt.Sprintf("query[%d].actions", r.pos)
+			if !report(path, fmt.Errorf("%s: can't be empty", path)) {
+				return false
+			}
 		}
-		const efmt = "query[%d].actions[%v]: %s"
 
 		var xa Action
 		for block.pos, xa = range block.Actions {
+			n := len(block.cdpActions)
 			err = r.parseAction(xa)
 			if err != nil {
-				return fmt.Errorf(efmt, r.pos, block.pos, err)
+				path := fmt.Sprintf("query[%d].actions[%d]", r.pos, block.pos)
+				if !report(path, fmt.Errorf("%s: %s", path, err)) {
+					return false
+				}
+				continue
 			}
+			actions := append([]chromedp.Action(nil), block.cdpActions[n:]...)
+			block.cdpActions = append(block.cdpActions[:n], r.observeAction(r.pos, block.pos, actions))
 		}
 
 		if err = r.parseRepeat(); err != nil {
-			return fmt.Errorf("query[%d].repeat: %s", r.pos, err)
+			path := fmt.Sprintf("query[%d].repeat", r.pos)
+			if !report(path, fmt.Errorf("%s: %s", path, err)) {
+				return false
+			}
 		}
 		if err = r.parseWhile(block.While); err != nil {
-			return fmt.Errorf("query[%d].while: %s", r.pos, err)
+			path := fmt.Sprintf("query[%d].while", r.pos)
+			if !report(path, fmt.Errorf("%s: %s", path, err)) {
+				return false
+			}
 		}
 
 	}
 
-	return nil
+	return true
 }
 
 func (r *Request) hasListeningEvents() bool {
 	for _, block := range r.Query {
 		for _, xa := range block.Actions {
-			if xa.Name() == "listen" {
+			switch xa.Name() {
+			case "listen":
 				return true
+			case "wait_for":
+				if xa.Arg(1) == "request" || xa.Arg(1) == "network_idle" {
+					return true
+				}
 			}
 		}
 	}
@@ -346,29 +597,15 @@ func (r *Request) parseWhile(xa *Action) error {
 	if err = xa.MustBeNonEmpty(); err != nil {
 		return err
 	}
-
-	switch xa.Name() {
-
-	case "element_exists":
-		if err = xa.MustArgCount(1); err != nil {
-			return err
-		}
-		block.cdpWhile = elementExists(xa.Arg(1), &block.cont)
-	case "element_visible":
-		if err = xa.MustArgCount(1); err != nil {
-			return err
-		}
-		sel := xa.Arg(1)
-		if strings.Contains(sel, "'") {
-			return fmt.Errorf(`element_visible selector contains "'"`)
-		}
-		block.cdpWhile = elementVisible(xa.Arg(1), &block.cont)
-
-	default:
+	c, ok := lookupCondition(xa.Name())
+	if !ok {
 		return fmt.Errorf("unknown while action \"%s\"", xa.Name())
 	}
-
-	return nil
+	if err = c.spec.checkArgs(*xa); err != nil {
+		return err
+	}
+	block.cdpWhile, err = c.build(&ActionScope{r, c.spec}, *xa, &block.cont)
+	return err
 }
 
 func (r *Request) parseAction(xa Action) error {
@@ -376,162 +613,339 @@ func (r *Request) parseAction(xa Action) error {
 	if err = xa.MustBeNonEmpty(); err != nil {
 		return err
 	}
+	a, ok := lookupAction(xa.Name())
+	if !ok {
+		return fmt.Errorf("unknown action name \"%s\"", xa.Name())
+	}
+	if err = a.spec.checkArgs(xa); err != nil {
+		return err
+	}
+	action, err := a.build(&ActionScope{r, a.spec}, xa)
+	if err != nil {
+		return err
+	}
+	r.appendActions(action)
+	return nil
+}
 
-	switch xa.Name() {
+// parseArtifactName validates a user-supplied artifact name, or derives one
+// from the position of the action if none was given.
+func (r *Request) parseArtifactName(name *string, kind string) error {
+	if *name == "" {
+		*name = fmt.Sprintf("%s_%d_%d", kind, r.pos, r.Query[r.pos].pos)
+	} else if !artifactNameRegexp.MatchString(*name) {
+		return fmt.Errorf(`invalid name "%s": only letters, digits, ".", "-" and "_" allowed`, *name)
+	}
+	if r.artifactNames == nil {
+		r.artifactNames = make(map[string]bool)
+	}
+	if r.artifactNames[*name] {
+		return fmt.Errorf(`duplicate artifact name "%s"`, *name)
+	}
+	r.artifactNames[*name] = true
+	return nil
+}
 
-	case "click":
-		if err = xa.MustArgCount(1); err != nil {
-			return err
+// parsePDFOptions accepts either the positional form with zero or four
+// margins (top, right, bottom, left) or named args.
+func parsePDFOptions(s *ActionScope, xa Action) (pdfOptions, error) {
+	var opts pdfOptions
+	var err error
+	if _, err = strconv.ParseFloat(xa.Arg(1), 64); err == nil || len(xa.Args()) == 0 {
+		if err = xa.MustArgCount(0, 4); err != nil {
+			return opts, err
 		}
-		r.appendActions(click(xa.Arg(1)))
-
-	case "eval":
-		if err = xa.MustArgCount(1); err != nil {
-			return err
+		for i, v := range xa.Args() {
+			if opts.margins[i], err = strconv.ParseFloat(v, 64); err != nil {
+				msg := "print_to_pdf: expected floating point margins"
+				return opts, fmt.Errorf("%s: %w", msg, err)
+			}
 		}
-		r.appendActions(evaluate(xa.Arg(1), &r.res.Out[r.pos]))
+		return opts, nil
+	}
 
-	case "hide_nav_buttons":
-		if err = xa.MustArgCount(0); err != nil {
-			return err
+	args, err := s.NamedArgs(xa)
+	if err != nil {
+		return opts, err
+	}
+	var paper string
+	for name, v := range args {
+		switch name {
+		case "margin_top":
+			opts.margins[0], err = strconv.ParseFloat(v, 64)
+		case "margin_right":
+			opts.margins[1], err = strconv.ParseFloat(v, 64)
+		case "margin_bottom":
+			opts.margins[2], err = strconv.ParseFloat(v, 64)
+		case "margin_left":
+			opts.margins[3], err = strconv.ParseFloat(v, 64)
+		case "paper":
+			paper = v
+		case "paper_width":
+			opts.paperWidth, err = strconv.ParseFloat(v, 64)
+		case "paper_height":
+			opts.paperHeight, err = strconv.ParseFloat(v, 64)
+		case "landscape":
+			opts.landscape, err = strconv.ParseBool(v)
+		case "scale":
+			opts.scale, err = strconv.ParseFloat(v, 64)
+			if err == nil && (opts.scale < 0.1 || opts.scale > 2) {
+				err = fmt.Errorf("must be between 0.1 and 2")
+			}
+		case "print_background":
+			opts.printBackground, err = strconv.ParseBool(v)
+		case "page_ranges":
+			opts.pageRanges = v
+			if !pageRangesRegexp.MatchString(v) {
+				err = fmt.Errorf(`expected e.g. "1-5, 8, 11-13"`)
+			}
+		case "prefer_css_page_size":
+			opts.preferCSSPageSize, err = strconv.ParseBool(v)
+		case "display_header_footer":
+			opts.displayHeaderFooter, err = strconv.ParseBool(v)
+		case "header_template":
+			opts.headerTemplate = v
+		case "footer_template":
+			opts.footerTemplate = v
+		case "name":
+			opts.name = v
 		}
-		r.appendActions(hideElements(navButtonSelector))
-
-	case "listen":
-		events := xa.Args()
-		events, err = parseEvents(events)
 		if err != nil {
-			return fmt.Errorf("listen: %s", err)
+			return opts, fmt.Errorf("print_to_pdf: invalid %s: %s", name, err)
 		}
-		r.appendActions(listen(&r.SessionID, events...))
+	}
 
-	case "load_html":
-		if err = xa.MustArgCount(1); err != nil {
-			return fmt.Errorf("load_html: expected a single (HTML) argument")
+	if paper != "" {
+		if opts.paperWidth != 0 || opts.paperHeight != 0 {
+			return opts, fmt.Errorf("print_to_pdf: paper and paper_width/paper_height are mutually exclusive")
 		}
-		r.appendActions(loadHTML(xa.Arg(1)))
-	case "load_tab":
-		if err = xa.MustArgCount(1); err != nil {
-			return err
+		size := paperSizes[paper]
+		opts.paperWidth, opts.paperHeight = size[0], size[1]
+	}
+	if opts.paperWidth < 0 || opts.paperHeight < 0 {
+		return opts, fmt.Errorf("print_to_pdf: paper dimensions must be positive")
+	}
+	for _, m := range opts.margins {
+		if m < 0 {
+			return opts, fmt.Errorf("print_to_pdf: margins must be non-negative")
 		}
-		return fmt.Errorf("load_tab must be the first action of the first action block")
+	}
+	if opts.headerTemplate != "" || opts.footerTemplate != "" {
+		opts.displayHeaderFooter = true
+	}
+	return opts, nil
+}
 
-	case "navigate":
-		if err = xa.MustArgCount(1); err != nil {
-			return err
+func parseScreenshotOptions(args map[string]string) (screenshotOptions, error) {
+	opts := screenshotOptions{
+		format:   page.CaptureScreenshotFormatPng,
+		quality:  -1,
+		fullPage: true,
+		scale:    1,
+	}
+	for name, v := range args {
+		var err error
+		switch name {
+		case "element":
+			if strings.Contains(v, "'") {
+				return opts, fmt.Errorf(`element contains "'"`)
+			}
+			opts.element = v
+		case "padding":
+			if strings.Contains(v, "'") {
+				return opts, fmt.Errorf(`padding contains "'"`)
+			}
+			opts.padding = v
+		case "format":
+			opts.format = page.CaptureScreenshotFormat(v)
+			if v == "jpg" {
+				opts.format = page.CaptureScreenshotFormatJpeg
+			}
+		case "quality":
+			opts.quality, err = strconv.Atoi(v)
+			if err == nil && (opts.quality < 0 || opts.quality > 100) {
+				err = fmt.Errorf("must be between 0 and 100")
+			}
+		case "clip":
+			opts.clip, err = parseClip(v)
+		case "full_page":
+			opts.fullPage, err = strconv.ParseBool(v)
+		case "scale":
+			opts.scale, err = strconv.ParseFloat(v, 64)
+			if err == nil && opts.scale <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "omit_background":
+			opts.omitBackground, err = strconv.ParseBool(v)
+		case "name":
+			opts.name = v
 		}
-		xurl := xa.Arg(1)
-		_, err = url.ParseRequestURI(xurl)
 		if err != nil {
-			return fmt.Errorf("navigate: non-URL argument: %s", err)
+			return opts, fmt.Errorf("invalid %s: %s", name, err)
 		}
-		r.appendActions(navigate(xurl))
+	}
+	switch {
+	case opts.element != "" && opts.clip != nil:
+		return opts, fmt.Errorf("element and clip are mutually exclusive")
+	case opts.padding != "" && opts.element == "":
+		return opts, fmt.Errorf("padding requires element")
+	case opts.quality >= 0 && opts.format == page.CaptureScreenshotFormatPng:
+		return opts, fmt.Errorf("quality requires jpeg or webp format")
+	case opts.omitBackground && opts.format == page.CaptureScreenshotFormatJpeg:
+		return opts, fmt.Errorf("omit_background requires png or webp format")
+	}
+	return opts, nil
+}
 
-	case "outer_html":
-		if err = xa.MustArgCount(0); err != nil {
-			return err
+func parseClip(v string) (*page.Viewport, error) {
+	fields := strings.Split(v, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("expected x,y,width,height")
+	}
+	var xs [4]float64
+	for i, f := range fields {
+		var err error
+		if xs[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return nil, fmt.Errorf("expected x,y,width,height")
 		}
-		r.appendActions(outerHTML(&r.res.Out[r.pos]))
+	}
+	if xs[2] <= 0 || xs[3] <= 0 {
+		return nil, fmt.Errorf("width and height must be positive")
+	}
+	return &page.Viewport{X: xs[0], Y: xs[1], Width: xs[2], Height: xs[3], Scale: 1}, nil
+}
 
-	case "print_to_pdf":
-		margins := make([]float64, 4)
-		if err = xa.MustArgCount(0, 4); err != nil {
-			return err
+func parseScrollOptions(args map[string]string) (scrollOptions, error) {
+	opts := scrollOptions{
+		interval:      DefaultScrollInterval,
+		stable:        DefaultScrollStable,
+		maxHeight:     DefaultScrollMaxHeight,
+		maxIterations: DefaultScrollMaxIterations,
+	}
+	for name, v := range args {
+		var err error
+		switch name {
+		case "interval":
+			opts.interval, err = time.ParseDuration(v)
+		case "stable":
+			opts.stable, err = time.ParseDuration(v)
+		case "step":
+			opts.step, err = strconv.Atoi(v)
+		case "max_height":
+			opts.maxHeight, err = strconv.Atoi(v)
+		case "max_iterations":
+			opts.maxIterations, err = strconv.Atoi(v)
 		}
-		for i, v := range xa.Args() {
-			if margins[i], err = strconv.ParseFloat(v, 64); err != nil {
-				msg := "print_to_pdf: expected floating point margins"
-				return fmt.Errorf("%s: %w", msg, err)
-			}
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %s", name, err)
 		}
+	}
+	switch {
+	case opts.step < 0:
+		return opts, fmt.Errorf("negative step (%d) not allowed", opts.step)
+	case opts.maxHeight < 0:
+		return opts, fmt.Errorf("negative max_height (%d) not allowed", opts.maxHeight)
+	case opts.maxIterations < 1:
+		return opts, fmt.Errorf("max_iterations must be at least 1")
+	case opts.interval > MaxRenderDelay:
+		opts.interval = MaxRenderDelay
+	}
+	return opts, nil
+}
 
-		r.appendActions(printToPDF(&r.res.pdf, margins))
+// parseWaitFor builds wait_for from its positional args and named args. The
+// ignore arg may be repeated, so its values are passed separately.
+func (r *Request) parseWaitFor(xa Action, args map[string]string, ignore []string) (chromedp.Action, error) {
+	cond, arg := xa.Arg(1), xa.Arg(2)
 
-	case "remove":
-		if len(xa.Args()) == 0 {
-			return fmt.Errorf("remove: expected at least one argument")
+	timeout := DefaultWaitTimeout
+	interval := DefaultWaitInterval
+	idle := r.networkIdle
+	if len(ignore) > 0 && cond != "network_idle" {
+		return nil, fmt.Errorf(`wait_for: argument "ignore" requires network_idle`)
+	}
+	for _, v := range ignore {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("wait_for: invalid ignore: %s", err)
 		}
-		for i, sel := range xa.Args() {
-			if strings.Contains(sel, "'") {
-				return fmt.Errorf(`remove[%d]: selector contains "'"`, i)
+		idle.ignore = append(idle.ignore[:len(idle.ignore):len(idle.ignore)], re)
+	}
+	for name, v := range args {
+		switch name {
+		case "ignore":
+			continue
+		case "interval", "timeout":
+		case "max_inflight":
+			if cond != "network_idle" {
+				return nil, fmt.Errorf(`wait_for: argument "%s" requires network_idle`, name)
 			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("wait_for: invalid max_inflight: %s", err)
+			}
+			if n < 0 {
+				return nil, fmt.Errorf("wait_for: max_inflight must be non-negative")
+			}
+			idle.maxInflight = n
+			continue
+		default:
+			return nil, fmt.Errorf(`wait_for: unknown argument "%s"`, name)
 		}
-		r.appendActions(removeElements(strings.Join(xa.Args(), ", ")))
-
-	case "remove_info_boxes":
-		if err = xa.MustArgCount(0); err != nil {
-			return err
-		}
-		r.appendActions(removeElements(infoBoxSelector))
-
-	case "remove_info_sections":
-		if err = xa.MustArgCount(0); err != nil {
-			return err
-		}
-		r.appendActions(removeElements(infoSectionSelector))
-
-	case "remove_nav_sections":
-		if err = xa.MustArgCount(0); err != nil {
-			return err
-		}
-		r.appendActions(removeElements(navSectionSelector))
-
-	case "screenshot":
-		args, err := xa.NamedArgs(1)
+		d, err := time.ParseDuration(v)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("wait_for: invalid %s: %s", name, err)
 		}
-		element, ok := args["element"]
-		if ok && strings.Contains(element, "'") {
-			return fmt.Errorf(`element contains "'"`)
+		if d <= 0 {
+			return nil, fmt.Errorf("wait_for: %s must be positive", name)
 		}
-		padding, ok := args["padding"]
-		if ok && strings.Contains(padding, "'") {
-			return fmt.Errorf(`padding contains "'"`)
+		if name == "interval" {
+			interval = d
+		} else {
+			timeout = min(d, MaxTimeout)
 		}
-		r.appendActions(screenshot(args, &r.res.img))
+	}
 
-	case "scroll":
-		if err = xa.MustArgCount(0, 1); err != nil {
-			return err
+	var wait waitCondition
+	switch cond {
+	case "exists":
+		wait = waitSelectorExists(arg, interval)
+	case "visible":
+		wait = waitSelectorVisible(arg, interval)
+	case "gone":
+		wait = waitSelectorGone(arg, interval)
+	case "js":
+		wait = waitJSTruthy(arg, interval)
+	case "url", "request":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("wait_for: invalid %s pattern: %s", cond, err)
 		}
-		if len(xa.Args()) == 0 {
-			r.appendActions(scrollToBottom())
+		if cond == "url" {
+			wait = waitURLMatches(re, interval)
 		} else {
-			r.appendActions(chromedp.ScrollIntoView(xa.Arg(1), chromedp.ByQuery))
+			wait = waitRequestFinished(re)
 		}
-
-	case "sleep":
-		if err = xa.MustArgCount(0, 1); err != nil {
-			return err
+	case "dom_stable":
+		quiet, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, fmt.Errorf("wait_for: invalid dom_stable duration: %s", err)
 		}
-		var delay time.Duration
-		if len(xa.Args()) == 0 {
-			delay = r.renderDelay
-		} else {
-			delay, err = time.ParseDuration(xa.Arg(1))
-			if err != nil {
-				return fmt.Errorf("sleep: invalid duration: %s", err)
-			}
+		wait = waitDOMStable(quiet, interval)
+	case "network_idle":
+		quiet, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, fmt.Errorf("wait_for: invalid network_idle duration: %s", err)
 		}
-		r.appendActions(chromedp.Sleep(delay))
-
-	default:
-		return fmt.Errorf("unknown action name \"%s\"", xa.Name())
-	}
-	return nil
-}
-
-func parseEvents(events []string) ([]string, error) {
-	if len(events) == 0 {
-		return defaultPageloadEvents(), nil
-	}
-	for i, event := range events {
-		if !validEvent(event) {
-			return events, fmt.Errorf("arg %d contains unknown event \"%s\"", i, event)
+		if quiet < 0 {
+			return nil, fmt.Errorf("wait_for: network_idle duration must not be negative")
 		}
+		idle.quiet = quiet
+		wait = waitNetworkIdle(&r.netTracker, idle, interval)
+	default:
+		return nil, fmt.Errorf(`wait_for: unknown condition "%s"`, cond)
 	}
-	return events, nil
+	return waitFor(fmt.Sprintf(`%s "%s"`, cond, arg), wait, timeout), nil
 }
 
 func defaultPageloadEvents() []string {
@@ -540,25 +954,10 @@ func defaultPageloadEvents() []string {
 	return events
 }
 
-func validEvent(event string) bool {
-	switch event {
-	case "DOMContentLoaded":
-	case "firstContentfulPaint":
-	case "firstImagePaint":
-	case "firstMeaningfulPaint":
-	case "firstMeaningfulPaintCandidate":
-	case "firstPaint":
-	case "init":
-	case "load":
-	case "networkAlmostIdle":
-	case "networkIdle":
-	default:
-		return false
-	}
-	return true
-}
-
 func (r *Request) appendActions(actions ...chromedp.Action) {
+	if r.pos >= len(r.Query) {
+		return // only validating the settings of a request without query
+	}
 	block := r.Query[r.pos]
 	block.cdpActions = append(block.cdpActions, actions...)
 }
@@ -606,6 +1005,18 @@ func (xa Action) NamedArgs(offset int) (map[string]string, error) {
 	return args, nil
 }
 
+// NamedArgValues returns every value given for the named arg after offset,
+// whereas NamedArgs keeps only the last one.
+func (xa Action) NamedArgValues(offset int, name string) []string {
+	var values []string
+	for i := offset; i+1 < len(xa); i += 2 {
+		if xa[i] == name {
+			values = append(values, xa[i+1])
+		}
+	}
+	return values
+}
+
 func (xa Action) MustArgCount(ns ...int) error {
 	switch len(ns) {
 	case 0:
@@ -0,0 +1,199 @@
+package decap
+
+//go:generate go run ./cmd/decap-schema -o schema.json
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+var (
+	actionType = reflect.TypeFor[Action]()
+
+	// argPatterns restrict the strings accepted for typed action arguments.
+	argPatterns = map[string]string{
+		ArgNumber:   `^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?$`,
+		ArgInteger:  `^[-+]?[0-9]+$`,
+		ArgBoolean:  `^(1|t|T|TRUE|true|True|0|f|F|FALSE|false|False)$`,
+		ArgDuration: `^[-+]?(0|(([0-9]+\.?[0-9]*|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+)$`,
+	}
+
+	// schemaFields overrides the schema derived from the Go type of a field,
+	// keyed by "Type.field" using the JSON name of the field.
+	schemaFields = map[string]map[string]any{
+		"Request.global_render_delay": {"$ref": "#/$defs/Duration"},
+		"Request.timeout":             {"$ref": "#/$defs/Duration"},
+		"Request.response_format": {
+			"enum": []string{"json", "multipart", "zip", "pdf", "png", "jpeg", "webp"},
+		},
+		"Request.callback_url":   {"type": "string", "format": "uri"},
+		"NetworkIdleBlock.quiet": {"$ref": "#/$defs/Duration"},
+		"ViewportBlock.orientation": {
+			"anyOf": []any{
+				map[string]any{"enum": []string{"landscape", "portrait"}},
+				map[string]any{"type": "null"},
+			},
+		},
+		"QueryBlock.while": {
+			"anyOf": []any{
+				map[string]any{"$ref": "#/$defs/WhileAction"},
+				map[string]any{"type": "null"},
+			},
+		},
+	}
+
+	// schemaRequired lists the fields which must be present, by JSON name.
+	schemaRequired = map[string][]string{
+		"Request":    {"query", "global_render_delay"},
+		"QueryBlock": {"actions"},
+		"Result":     {"err", "out"},
+		"Artifact":   {"name", "type", "data"},
+	}
+)
+
+// JSONSchema returns a JSON Schema (draft 2020-12) of requests, derived from
+// the Go types and the action specs. The definitions also cover Result.
+func JSONSchema() ([]byte, error) {
+	g := schemaGen{defs: make(map[string]any)}
+	g.define(reflect.TypeFor[Request]())
+	g.define(reflect.TypeFor[Result]())
+	g.defs["Duration"] = map[string]any{
+		"type":        "string",
+		"pattern":     argPatterns[ArgDuration],
+		"description": `Go duration, e.g. "1.5s" or "2m30s"`,
+	}
+	g.defs["Action"] = g.actions("action", ActionSpecs())
+	g.defs["WhileAction"] = g.actions("while", WhileSpecs())
+
+	schema := map[string]any{
+		"$schema": schemaDialect,
+		"title":   "Decap request",
+		"$ref":    "#/$defs/Request",
+		"$defs":   g.defs,
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+type schemaGen struct {
+	defs map[string]any
+}
+
+func (g *schemaGen) ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+// define adds a definition of the struct type t, returning a reference.
+func (g *schemaGen) define(t reflect.Type) map[string]any {
+	name := t.Name()
+	if _, ok := g.defs[name]; ok {
+		return g.ref(name)
+	}
+	props := make(map[string]any)
+	def := map[string]any{"type": "object", "properties": props}
+	g.defs[name] = def
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if !f.IsExported() || tag == "-" || f.Type.Kind() == reflect.Func {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if s, ok := schemaFields[name+"."+tag]; ok {
+			props[tag] = s
+		} else {
+			props[tag] = g.typeSchema(f.Type)
+		}
+	}
+	if required, ok := schemaRequired[name]; ok {
+		def["required"] = required
+	}
+	return g.ref(name)
+}
+
+func (g *schemaGen) typeSchema(t reflect.Type) map[string]any {
+	if t == actionType {
+		return g.ref("Action")
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{g.typeSchema(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.Struct:
+		return g.define(t)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// actions returns a schema accepting any of the actions in specs, each of
+// which is defined as "<prefix>.<name>". The specs themselves are included
+// under "x-decap-args" and "x-decap-named-args".
+func (g *schemaGen) actions(prefix string, specs map[string]ActionSpec) map[string]any {
+	var oneOf []any
+	for _, name := range sortedSpecNames(specs) {
+		spec := specs[name]
+		items := []any{map[string]any{"const": name}}
+		minItems := 1
+		for _, arg := range spec.Args {
+			items = append(items, argSchema(arg))
+			if !arg.Optional {
+				minItems++
+			}
+		}
+		def := map[string]any{
+			"type":         "array",
+			"description":  spec.Description,
+			"prefixItems":  items,
+			"minItems":     minItems,
+			"x-decap-args": append([]ArgSpec{}, spec.Args...),
+		}
+		switch {
+		case spec.Variadic:
+			def["items"] = items[len(items)-1]
+		case len(spec.Named) > 0:
+			def["items"] = map[string]any{"type": "string", "minLength": 1}
+			def["x-decap-named-args"] = spec.Named
+		default:
+			def["items"] = false
+		}
+		g.defs[prefix+"."+name] = def
+		oneOf = append(oneOf, g.ref(prefix+"."+name))
+	}
+	return map[string]any{"oneOf": oneOf}
+}
+
+func argSchema(arg ArgSpec) map[string]any {
+	s := map[string]any{"type": "string", "minLength": 1, "title": arg.Name}
+	if arg.Description != "" {
+		s["description"] = arg.Description
+	}
+	if len(arg.Enum) > 0 {
+		s["enum"] = arg.Enum
+	}
+	if pattern, ok := argPatterns[arg.Type]; ok {
+		s["pattern"] = pattern
+	}
+	if arg.Type == ArgURL {
+		s["format"] = "uri"
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package decap
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestGeneratedFiles checks that the checked-in generated files are up to
+// date; run go generate if it fails.
+func TestGeneratedFiles(t *testing.T) {
+	schema, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubs, err := TypeStubs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{
+		"schema.json":       append(schema, '\n'),
+		"spectura_types.go": stubs,
+	} {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date, run go generate", name)
+		}
+	}
+}
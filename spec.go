@@ -0,0 +1,283 @@
+package decap
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Argument types of ArgSpec.
+const (
+	ArgString     = "string"
+	ArgSelector   = "selector"
+	ArgTarget     = "target" // a selector or "x,y" coordinates
+	ArgURL        = "url"
+	ArgHTML       = "html"
+	ArgJavaScript = "javascript"
+	ArgRegexp     = "regexp"
+	ArgDuration   = "duration"
+	ArgNumber     = "number"
+	ArgInteger    = "integer"
+	ArgBoolean    = "boolean"
+)
+
+// ArgSpec describes a positional or named argument of an action. All
+// arguments are passed as strings, so Type says how the string is parsed.
+type ArgSpec struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Enum        []string `json:"enum,omitempty"`
+	Optional    bool     `json:"optional,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// ActionSpec describes the arguments of an action. The positional Args are
+// followed by either repetitions of the last positional arg if Variadic is
+// set, or by name/value pairs of the Named args.
+type ActionSpec struct {
+	Description string    `json:"description"`
+	Args        []ArgSpec `json:"args"`
+	Variadic    bool      `json:"variadic,omitempty"`
+	Named       []ArgSpec `json:"named,omitempty"`
+}
+
+// ActionBuilder builds the chromedp action carrying out a query action.
+// It is called while the request is parsed, after the count, types and
+// enums of the positional arguments have been checked against the spec, and
+// should reject arguments which are invalid in other ways. Named arguments
+// are checked when read with ActionScope.NamedArgs.
+type ActionBuilder func(s *ActionScope, xa Action) (chromedp.Action, error)
+
+// ConditionBuilder builds the chromedp action evaluating a QueryBlock.While
+// condition, which stores whether to run the block (again) in res.
+type ConditionBuilder func(s *ActionScope, xa Action, res *bool) (chromedp.Action, error)
+
+type registeredAction struct {
+	spec  ActionSpec
+	build ActionBuilder
+}
+
+type registeredCondition struct {
+	spec  ActionSpec
+	build ConditionBuilder
+}
+
+var registry = struct {
+	sync.RWMutex
+	actions    map[string]registeredAction
+	conditions map[string]registeredCondition
+}{
+	actions:    make(map[string]registeredAction),
+	conditions: make(map[string]registeredCondition),
+}
+
+// RegisterAction makes an action available to query blocks under name. The
+// spec is used for checking the arguments and for the JSON Schema. It
+// panics if the name is taken or build is nil, so it is meant to be called
+// from init functions.
+func RegisterAction(name string, spec ActionSpec, build ActionBuilder) {
+	registry.Lock()
+	defer registry.Unlock()
+	if name == "" || build == nil {
+		panic("decap: RegisterAction requires a name and a builder")
+	}
+	if _, dup := registry.actions[name]; dup {
+		panic(fmt.Sprintf("decap: RegisterAction called twice for action %s", name))
+	}
+	registry.actions[name] = registeredAction{spec, build}
+}
+
+// RegisterCondition is like RegisterAction, but for QueryBlock.While.
+func RegisterCondition(name string, spec ActionSpec, build ConditionBuilder) {
+	registry.Lock()
+	defer registry.Unlock()
+	if name == "" || build == nil {
+		panic("decap: RegisterCondition requires a name and a builder")
+	}
+	if _, dup := registry.conditions[name]; dup {
+		panic(fmt.Sprintf("decap: RegisterCondition called twice for condition %s", name))
+	}
+	registry.conditions[name] = registeredCondition{spec, build}
+}
+
+// ActionSpecs returns the specs of the registered actions by name.
+func ActionSpecs() map[string]ActionSpec {
+	registry.RLock()
+	defer registry.RUnlock()
+	specs := make(map[string]ActionSpec, len(registry.actions))
+	for name, a := range registry.actions {
+		specs[name] = a.spec
+	}
+	return specs
+}
+
+// WhileSpecs returns the specs of the registered QueryBlock.While
+// conditions by name.
+func WhileSpecs() map[string]ActionSpec {
+	registry.RLock()
+	defer registry.RUnlock()
+	specs := make(map[string]ActionSpec, len(registry.conditions))
+	for name, c := range registry.conditions {
+		specs[name] = c.spec
+	}
+	return specs
+}
+
+func lookupAction(name string) (registeredAction, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	a, ok := registry.actions[name]
+	return a, ok
+}
+
+func lookupCondition(name string) (registeredCondition, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	c, ok := registry.conditions[name]
+	return c, ok
+}
+
+// checkArgs checks the number of arguments of xa against the spec, and the
+// type and enum of its positional arguments. Actions with named args may
+// have any number of arguments after the positional ones, which are checked
+// by namedArgs.
+func (spec ActionSpec) checkArgs(xa Action) error {
+	required := 0
+	for _, arg := range spec.Args {
+		if !arg.Optional {
+			required++
+		}
+	}
+	n := len(xa.Args())
+	switch {
+	case n < required:
+		return fmt.Errorf("%s: not enough arguments", xa.Name())
+	case n > len(spec.Args) && !spec.Variadic && len(spec.Named) == 0:
+		return fmt.Errorf("%s: too many arguments (\"%s\")", xa.Name(), xa.Arg(len(spec.Args)+1))
+	}
+	for i, v := range xa.Args() {
+		var arg ArgSpec
+		switch {
+		case i < len(spec.Args):
+			arg = spec.Args[i]
+		case spec.Variadic:
+			arg = spec.Args[len(spec.Args)-1]
+		default:
+			return nil
+		}
+		if err := arg.check(v); err != nil {
+			return fmt.Errorf("%s: %s", xa.Name(), err)
+		}
+	}
+	return nil
+}
+
+// namedArgs returns the name/value pairs following the positional args of
+// xa, checking the names, types and enums against spec.Named.
+func (spec ActionSpec) namedArgs(xa Action) (map[string]string, error) {
+	args, err := xa.NamedArgs(min(len(spec.Args)+1, len(xa)))
+	if err != nil {
+		return nil, err
+	}
+	// check each pair rather than the map, where repeated args collapse
+	for i := len(spec.Args) + 1; i+1 < len(xa); i += 2 {
+		name, v := xa[i], xa[i+1]
+		j := slices.IndexFunc(spec.Named, func(arg ArgSpec) bool { return arg.Name == name })
+		if j < 0 {
+			return nil, fmt.Errorf(`%s: unknown argument "%s"`, xa.Name(), name)
+		}
+		if err = spec.Named[j].check(v); err != nil {
+			return nil, fmt.Errorf("%s: %s", xa.Name(), err)
+		}
+	}
+	return args, nil
+}
+
+// check checks a value of the argument against its type and enum.
+func (arg ArgSpec) check(v string) error {
+	if len(arg.Enum) > 0 && !slices.Contains(arg.Enum, v) {
+		return fmt.Errorf(`invalid %s "%s": expected one of %s`, arg.Name, v, strings.Join(arg.Enum, ", "))
+	}
+	var err error
+	switch arg.Type {
+	case ArgTarget:
+		_, err = parseMouseTarget(v)
+	case ArgURL:
+		_, err = url.ParseRequestURI(v)
+	case ArgRegexp:
+		_, err = regexp.Compile(v)
+	case ArgDuration:
+		_, err = time.ParseDuration(v)
+	case ArgNumber:
+		_, err = strconv.ParseFloat(v, 64)
+	case ArgInteger:
+		_, err = strconv.Atoi(v)
+	case ArgBoolean:
+		_, err = strconv.ParseBool(v)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", arg.Name, err)
+	}
+	return nil
+}
+
+func sortedSpecNames(specs map[string]ActionSpec) []string {
+	return slices.Sorted(maps.Keys(specs))
+}
+
+// ActionScope is the part of the request being parsed which action builders
+// may use.
+type ActionScope struct {
+	r    *Request
+	spec ActionSpec // of the action being built
+}
+
+// NamedArgs returns the named args of the action being built, which follow
+// its positional args, after checking them against the spec.
+func (s *ActionScope) NamedArgs(xa Action) (map[string]string, error) {
+	return s.spec.namedArgs(xa)
+}
+
+// Block and Action return the position of the action being built.
+func (s *ActionScope) Block() int {
+	return s.r.pos
+}
+
+func (s *ActionScope) Action() int {
+	return s.r.Query[s.r.pos].pos
+}
+
+// Out returns the output of the current block, which actions may append to
+// when executed.
+func (s *ActionScope) Out() *[]string {
+	return &s.r.res.Out[s.r.pos]
+}
+
+func (s *ActionScope) RenderDelay() time.Duration {
+	return s.r.renderDelay
+}
+
+func (s *ActionScope) Timeout() time.Duration {
+	return s.r.timeout
+}
+
+// ArtifactName validates a user-supplied artifact name and reserves it,
+// deriving a name from kind and the position of the action if name is "".
+func (s *ActionScope) ArtifactName(name, kind string) (string, error) {
+	err := s.r.parseArtifactName(&name, kind)
+	return name, err
+}
+
+// AddArtifact adds an artifact to the result. It is meant to be called when
+// the action is executed, with a name reserved by ArtifactName.
+func (s *ActionScope) AddArtifact(name, typ string, data []byte) {
+	s.r.res.addArtifact(name, typ, data)
+}
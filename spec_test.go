@@ -0,0 +1,89 @@
+package decap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// validateAction returns the problems Validate finds with a request running
+// the action after navigating.
+func validateAction(t *testing.T, action string) []*ValidationError {
+	t.Helper()
+	body := fmt.Sprintf(`{"global_render_delay": "1s", "query": [{"actions": [["navigate", "http://example.com"], %s]}]}`, action)
+	_, problems := new(Request).Validate(strings.NewReader(body))
+	return problems
+}
+
+// TestWaitForUncheckedArgs checks that parseWaitFor reports invalid args
+// itself rather than relying on the spec.
+func TestWaitForUncheckedArgs(t *testing.T) {
+	tests := []struct {
+		args   map[string]string
+		ignore []string
+	}{
+		{map[string]string{"timeout": "soon"}, nil},
+		{map[string]string{"interval": "-1s"}, nil},
+		{map[string]string{"max_inflight": "many"}, nil},
+		{map[string]string{"retries": "3"}, nil},
+		{nil, []string{"a", "("}},
+	}
+	xa := NewAction("wait_for", "network_idle", "1s")
+	for _, test := range tests {
+		if _, err := new(Request).parseWaitFor(xa, test.args, test.ignore); err == nil {
+			t.Errorf("args %v and ignore %v: no error", test.args, test.ignore)
+		}
+	}
+}
+
+func TestActionArgsCheckedAgainstSpec(t *testing.T) {
+	tests := []struct {
+		action string
+		err    string // substring of the problem, "" if valid
+	}{
+		{`["navigate", "http://example.com"]`, ""},
+		{`["navigate", "example"]`, "navigate: invalid url"},
+		{`["sleep", "2s"]`, ""},
+		{`["sleep", "soon"]`, "sleep: invalid duration"},
+		{`["click_at", "10", "20.5"]`, ""},
+		{`["click_at", "10", "y"]`, "click_at: invalid y"},
+		{`["drag", "#a", "10,20"]`, ""},
+		{`["drag", "#a"]`, "drag: "},
+		{`["drag", "1.2.3,4", "#b"]`, "drag: invalid from"},
+		{`["hover", "#a"]`, ""},
+		{`["dblclick"]`, "dblclick: "},
+		{`["context_click", "#a", "#b"]`, "context_click: "},
+		{`["listen", "load", "networkIdle"]`, ""},
+		{`["listen", "load", "loaded"]`, `listen: invalid event "loaded"`},
+		{`["wait_for", "exists", "#a", "timeout", "5s"]`, ""},
+		{`["wait_for", "appears", "#a"]`, `wait_for: invalid condition "appears"`},
+		{`["wait_for", "exists", "#a", "timeout", "x"]`, "wait_for: invalid timeout"},
+		{`["wait_for", "exists", "#a", "retries", "3"]`, `wait_for: unknown argument "retries"`},
+		{`["wait_for", "network_idle", "500ms", "ignore", "("]`, "wait_for: invalid ignore"},
+		{`["wait_for", "network_idle", "500ms", "ignore", "a", "ignore", "b"]`, ""},
+		{`["wait_for", "network_idle", "500ms", "ignore", "(", "ignore", "b"]`, "wait_for: invalid ignore"},
+		{`["wait_for", "exists", "#a", "ignore", "a"]`, `wait_for: argument "ignore" requires network_idle`},
+		{`["screenshot", "format", "jpg", "quality", "80"]`, ""},
+		{`["screenshot", "format", "gif"]`, `screenshot: invalid format "gif"`},
+		{`["screenshot", "quality", "high"]`, "screenshot: invalid quality"},
+		{`["screenshot", "full_page", "maybe"]`, "screenshot: invalid full_page"},
+		{`["screenshot", "zoom", "2"]`, `screenshot: unknown argument "zoom"`},
+		{`["scroll_until_stable", "step", "100", "stable", "1s"]`, ""},
+		{`["scroll_until_stable", "stable", "1"]`, "scroll_until_stable: invalid stable"},
+		{`["print_to_pdf", "1", "0.5", "1", "0.5"]`, ""},
+		{`["print_to_pdf", "paper", "a4", "landscape", "true"]`, ""},
+		{`["print_to_pdf", "paper", "a0"]`, `print_to_pdf: invalid paper "a0"`},
+		{`["print_to_pdf", "margins", "1"]`, `print_to_pdf: unknown argument "margins"`},
+	}
+	for _, test := range tests {
+		problems := validateAction(t, test.action)
+		switch {
+		case test.err == "" && len(problems) > 0:
+			t.Errorf("%s: unexpected problems %v", test.action, problems)
+		case test.err != "" && len(problems) != 1:
+			t.Errorf("%s: got problems %v, want one containing %q", test.action, problems, test.err)
+		case test.err != "" && !strings.Contains(problems[0].Message, test.err):
+			t.Errorf("%s: got %q, want it to contain %q", test.action, problems[0].Message, test.err)
+		}
+	}
+}
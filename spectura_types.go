@@ -0,0 +1,86 @@
+//go:build ignore
+
+// Code generated by decap-schema -stubs. DO NOT EDIT.
+
+// Type stubs of the JSON documents of Decap, for consumers which copy them
+// into a package decap of their own.
+
+package decap
+
+type Action []string
+
+type QueryBlock struct {
+	Actions []Action `json:"actions"`
+	Repeat  *int     `json:"repeat"`
+	While   *Action  `json:"while"`
+}
+
+type ViewportBlock struct {
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Orientation *string  `json:"orientation"`
+	Mobile      bool     `json:"mobile"`
+	Scale       *float64 `json:"scale"`
+}
+
+type NetworkIdleBlock struct {
+	MaxInflight int      `json:"max_inflight"`
+	Quiet       string   `json:"quiet"`
+	Ignore      []string `json:"ignore"`
+}
+
+type Request struct {
+	Query            []*QueryBlock     `json:"query"`
+	CallbackURL      string            `json:"callback_url"`
+	EmulateViewport  *ViewportBlock    `json:"emulate_viewport"`
+	ForwardUserAgent bool              `json:"forward_user_agent"`
+	NetworkIdle      *NetworkIdleBlock `json:"network_idle"`
+	RenderDelay      string            `json:"global_render_delay"`
+	ResponseFormat   string            `json:"response_format"`
+	ReuseTab         bool              `json:"reuse_tab"`
+	ReuseWindow      bool              `json:"reuse_window"`
+	SessionID        string            `json:"sessionid"`
+	Timeout          string            `json:"timeout"`
+	Timings          bool              `json:"timings"`
+	Trace            bool              `json:"trace"`
+}
+
+type Artifact struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+type ActionTiming struct {
+	Action     int     `json:"action"`
+	Name       string  `json:"name"`
+	Iteration  int     `json:"iteration"`
+	StartMS    float64 `json:"start_ms"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type BlockTiming struct {
+	Block      int             `json:"block"`
+	Iterations int             `json:"iterations"`
+	StartMS    float64         `json:"start_ms"`
+	DurationMS float64         `json:"duration_ms"`
+	Actions    []*ActionTiming `json:"actions"`
+}
+
+type Timings struct {
+	WindowWaitMS   float64        `json:"window_wait_ms"`
+	WindowCreateMS float64        `json:"window_create_ms"`
+	TabCreateMS    float64        `json:"tab_create_ms"`
+	TotalMS        float64        `json:"total_ms"`
+	Blocks         []*BlockTiming `json:"blocks"`
+}
+
+type Result struct {
+	Err       []string    `json:"err"`
+	Out       [][]string  `json:"out"`
+	TabID     string      `json:"tab_id"`
+	WindowID  string      `json:"window_id"`
+	Artifacts []*Artifact `json:"artifacts,omitempty"`
+	Timings   *Timings    `json:"timings,omitempty"`
+}
@@ -0,0 +1,124 @@
+package decap
+
+//go:generate go run ./cmd/decap-schema -stubs -o spectura_types.go
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// stubsHeader starts the generated stubs. The build constraint keeps them
+// out of this package, where they would clash with the real types.
+const stubsHeader = `//go:build ignore
+
+// Code generated by decap-schema -stubs. DO NOT EDIT.
+
+// Type stubs of the JSON documents of Decap, for consumers which copy them
+// into a package decap of their own.
+
+package decap
+`
+
+// TypeStubs returns Go source declaring the request and result types with
+// their JSON fields only, i.e. without methods and unexported or "-" fields.
+// It replaces the type stubs scraped from query.go by spectura_typegen.awk.
+func TypeStubs() ([]byte, error) {
+	g := stubGen{pkgPath: actionType.PkgPath(), defined: make(map[string]bool), imports: make(map[string]bool)}
+	g.define(reflect.TypeFor[Request]())
+	g.define(reflect.TypeFor[Result]())
+
+	var buf bytes.Buffer
+	buf.WriteString(stubsHeader)
+	if len(g.imports) > 0 {
+		buf.WriteString("\nimport (\n")
+		for _, path := range slices.Sorted(maps.Keys(g.imports)) {
+			fmt.Fprintf(&buf, "\t%q\n", path)
+		}
+		buf.WriteString(")\n")
+	}
+	buf.Write(g.decls.Bytes())
+	return format.Source(buf.Bytes())
+}
+
+type stubGen struct {
+	pkgPath string
+	defined map[string]bool
+	imports map[string]bool
+	decls   bytes.Buffer
+}
+
+// define declares the named type t of this package and the types it uses.
+func (g *stubGen) define(t reflect.Type) {
+	if g.defined[t.Name()] {
+		return
+	}
+	g.defined[t.Name()] = true
+
+	var decl strings.Builder
+	if t.Kind() != reflect.Struct {
+		fmt.Fprintf(&decl, "\ntype %s %s\n", t.Name(), g.underlying(t))
+		g.decls.WriteString(decl.String())
+		return
+	}
+	fmt.Fprintf(&decl, "\ntype %s struct {\n", t.Name())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if !f.IsExported() || tag == "-" || f.Type.Kind() == reflect.Func {
+			continue
+		}
+		if f.Anonymous {
+			fmt.Fprintf(&decl, "\t%s", g.typeString(f.Type))
+		} else {
+			fmt.Fprintf(&decl, "\t%s %s", f.Name, g.typeString(f.Type))
+		}
+		if f.Tag != "" {
+			fmt.Fprintf(&decl, " `%s`", f.Tag)
+		}
+		decl.WriteString("\n")
+	}
+	decl.WriteString("}\n")
+	// the types used by t were declared while formatting its fields
+	g.decls.WriteString(decl.String())
+}
+
+// typeString returns the Go syntax of t, defining the types of this package
+// it refers to and importing those of other packages.
+func (g *stubGen) typeString(t reflect.Type) string {
+	switch {
+	case t.Name() != "" && t.PkgPath() == g.pkgPath:
+		g.define(t)
+		return t.Name()
+	case t.Name() != "" && t.PkgPath() != "":
+		g.imports[t.PkgPath()] = true
+		return t.String()
+	case t.Name() != "":
+		return t.Name()
+	}
+	return g.underlying(t)
+}
+
+func (g *stubGen) underlying(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return "*" + g.typeString(t.Elem())
+	case reflect.Slice:
+		if t.Elem() == reflect.TypeFor[byte]() {
+			return "[]byte"
+		}
+		return "[]" + g.typeString(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), g.typeString(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", g.typeString(t.Key()), g.typeString(t.Elem()))
+	case reflect.Interface:
+		return "any"
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,141 @@
+package decap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// MaxTabLifetime bounds how long an interactive tab may stay open, however
+// active it is.
+const MaxTabLifetime = 30 * time.Minute
+
+// Tab is an interactive browser tab which executes one action at a time,
+// using the same action vocabulary as query blocks.
+type Tab struct {
+	mu     sync.Mutex
+	engine *Engine
+	req    *Request
+	tab    session
+	closed bool
+}
+
+// OpenTab opens a tab with the default engine.
+func OpenTab(ctx context.Context, r *Request) (*Tab, error) {
+	return DefaultEngine().OpenTab(ctx, r)
+}
+
+// OpenTab opens a tab configured by the settings of r (viewport, render
+// delay, timeout, window session etc.), giving up when ctx is done. The
+// query of r is ignored, and the timeout applies to each action rather than
+// to the tab. The window of the tab is kept open until the tab is closed.
+func (e *Engine) OpenTab(ctx context.Context, r *Request) (*Tab, error) {
+	e.Start()
+	r.Query = []*QueryBlock{{}}
+	r.pos = 0
+	r.engine = e
+	var err error
+	if !r.parseSettings(stopAtFirst(&err)) {
+		return nil, err
+	}
+
+	window, err := e.loadWindow(ctx, r.SessionID, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	r.SessionID = window.id
+	e.pinWindow(window.id, 1)
+	tab := window.createSiblingTab(MaxTabLifetime)
+	r.tabID = tab.id
+
+	// track requests for the life of the tab, not just the setup
+	r.netTracker.listen(tab.ctx)
+	setup := append(r.Query[0].cdpActions, network.Enable(), enableLifecycleEvents())
+	setupCtx, cancel := context.WithTimeout(tab.ctx, r.timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	if err = chromedp.Run(setupCtx, setup...); err != nil {
+		tab.shutdown()
+		e.pinWindow(window.id, -1)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	e.interactive.Add(1)
+	return &Tab{engine: e, req: r, tab: tab}, nil
+}
+
+func (t *Tab) ID() string {
+	return t.tab.id
+}
+
+func (t *Tab) WindowID() string {
+	return t.req.SessionID
+}
+
+// ParseError is returned by Tab.Do if the action itself is invalid, as
+// opposed to failing while executing.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Do parses and executes a single action, returning the outputs and
+// artifacts it produced in the first (and only) block of the result.
+func (t *Tab) Do(ctx context.Context, xa Action) (*Result, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.req
+	block := &QueryBlock{Actions: []Action{xa}}
+	r.Query = []*QueryBlock{block}
+	r.pos = 0
+	r.artifactNames = nil
+	r.res = Result{Err: make([]string, 1), Out: [][]string{{}}}
+
+	if xa.Name() == "load_tab" {
+		return nil, &ParseError{fmt.Errorf("load_tab can't be used in an interactive tab")}
+	}
+	if err := r.parseAction(xa); err != nil {
+		return nil, &ParseError{err}
+	}
+
+	actx, cancel := context.WithTimeout(t.tab.ctx, r.timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	res := r.res
+	res.TabID, res.WindowID = t.tab.id, r.SessionID
+	start := time.Now()
+	err := chromedp.Run(actx, block.cdpActions...)
+	t.engine.metrics.observeAction(xa.Name(), start, err)
+	res.Out, res.Artifacts = r.res.Out, r.res.Artifacts
+	t.engine.metrics.countArtifacts(res.Artifacts)
+	return &res, err
+}
+
+// Close closes the tab, leaving its window to the usual idle timeout.
+func (t *Tab) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		t.engine.interactive.Add(-1)
+		t.engine.pinWindow(t.req.SessionID, -1)
+	}
+	t.tab.shutdown()
+}
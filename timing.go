@@ -0,0 +1,76 @@
+package decap
+
+import "time"
+
+// Timings break down where the time of a request went. They are included in
+// the result if Request.Timings is set. Durations are in milliseconds, and
+// starts are relative to the start of Execute.
+type Timings struct {
+	// WindowWaitMS is the time spent waiting for the engine to hand out a
+	// window (or a kept tab), not counting WindowCreateMS.
+	WindowWaitMS   float64        `json:"window_wait_ms"`
+	WindowCreateMS float64        `json:"window_create_ms"`
+	TabCreateMS    float64        `json:"tab_create_ms"`
+	TotalMS        float64        `json:"total_ms"`
+	Blocks         []*BlockTiming `json:"blocks"`
+}
+
+// BlockTiming covers all iterations of a block, including the evaluation
+// of its while condition. Its actions are listed once per iteration.
+type BlockTiming struct {
+	Block      int             `json:"block"`
+	Iterations int             `json:"iterations"`
+	StartMS    float64         `json:"start_ms"`
+	DurationMS float64         `json:"duration_ms"`
+	Actions    []*ActionTiming `json:"actions"`
+}
+
+type ActionTiming struct {
+	Action     int     `json:"action"`
+	Name       string  `json:"name"`
+	Iteration  int     `json:"iteration"`
+	StartMS    float64 `json:"start_ms"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// TimingsError is returned by Execute if a request with Timings set fails.
+// Its timings cover the request up to the failure, the last action timing
+// being the failed action if an action failed.
+type TimingsError struct {
+	Err     error
+	Timings *Timings
+}
+
+func (e *TimingsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TimingsError) Unwrap() error {
+	return e.Err
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// timeAction records the timing of an action of the current block, if
+// timings were requested.
+func (r *Request) timeAction(actionPos int, name string, start time.Time, err error) {
+	t := r.res.Timings
+	if t == nil || len(t.Blocks) == 0 {
+		return
+	}
+	at := &ActionTiming{
+		Action:     actionPos,
+		Name:       name,
+		Iteration:  r.iteration,
+		StartMS:    milliseconds(start.Sub(r.start)),
+		DurationMS: milliseconds(time.Since(start)),
+	}
+	if err != nil {
+		at.Error = err.Error()
+	}
+	block := t.Blocks[len(t.Blocks)-1]
+	block.Actions = append(block.Actions, at)
+}
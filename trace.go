@@ -0,0 +1,187 @@
+package decap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// traceScreenshotQuality is the JPEG quality of trace screenshots, which
+	// is kept low as there is one per action.
+	traceScreenshotQuality = 40
+
+	// traceScreenshotWidth is the width wider viewports are scaled down to
+	// in trace screenshots.
+	traceScreenshotWidth = 480
+
+	// traceCaptureTimeout bounds capturing the tab state after an action,
+	// which is done even if the action timed out.
+	traceCaptureTimeout = 5 * time.Second
+
+	// maxTraceConsole limits the console messages kept per step.
+	maxTraceConsole = 100
+
+	// DefaultTraceSteps is the number of trace steps kept by default, see
+	// WithTraceSteps.
+	DefaultTraceSteps = 100
+)
+
+// Trace records the state of the tab after each action of a request with
+// Request.Trace set. Only the last steps are kept, Dropped counting the
+// steps before them.
+type Trace struct {
+	Steps   []*TraceStep `json:"steps"`
+	Dropped int          `json:"dropped,omitempty"`
+}
+
+// TraceStep is the state of the tab after an action: its URL, a JPEG
+// screenshot, and the console messages and uncaught exceptions logged while
+// the action ran. URL and Screenshot are empty if they couldn't be captured.
+type TraceStep struct {
+	Block      int      `json:"block"`
+	Action     int      `json:"action"`
+	Name       string   `json:"name"`
+	Iteration  int      `json:"iteration"`
+	DurationMS float64  `json:"duration_ms"`
+	URL        string   `json:"url"`
+	Console    []string `json:"console"`
+	Error      string   `json:"error,omitempty"`
+	Screenshot []byte   `json:"screenshot,omitempty"`
+}
+
+// TraceError is returned by Execute if a request with Trace set fails. Its
+// trace ends with the failed action, unless the request failed elsewhere.
+// Successful requests don't return their trace.
+type TraceError struct {
+	Err   error
+	Trace *Trace
+}
+
+func (e *TraceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TraceError) Unwrap() error {
+	return e.Err
+}
+
+type tracer struct {
+	mu      sync.Mutex
+	console []string     // messages since the last step
+	steps   []*TraceStep // ring buffer of the last maxSteps steps
+	next    int          // index of the oldest step once steps is full
+	dropped int
+
+	// maxSteps limits the steps kept, the oldest being dropped, so repeated
+	// blocks don't use memory without bound
+	maxSteps int
+}
+
+func (t *tracer) add(step *TraceStep) {
+	if len(t.steps) < t.maxSteps {
+		t.steps = append(t.steps, step)
+		return
+	}
+	t.steps[t.next] = step
+	t.next = (t.next + 1) % t.maxSteps
+	t.dropped++
+}
+
+// result returns the steps kept, oldest first.
+func (t *tracer) result() *Trace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	steps := append(slices.Clone(t.steps[t.next:]), t.steps[:t.next]...)
+	return &Trace{Steps: steps, Dropped: t.dropped}
+}
+
+// listen collects the console messages of the tab until ctx is done.
+func (t *tracer) listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev any) {
+		var msg string
+		switch e := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			args := make([]string, len(e.Args))
+			for i, arg := range e.Args {
+				args[i] = formatRemoteObject(arg)
+			}
+			msg = fmt.Sprintf("%s: %s", e.Type, strings.Join(args, " "))
+		case *runtime.EventExceptionThrown:
+			msg = "exception: " + e.ExceptionDetails.Text
+			if ex := e.ExceptionDetails.Exception; ex != nil && ex.Description != "" {
+				msg = "exception: " + ex.Description
+			}
+		default:
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if len(t.console) < maxTraceConsole {
+			t.console = append(t.console, msg)
+		}
+	})
+}
+
+func formatRemoteObject(obj *runtime.RemoteObject) string {
+	if len(obj.Value) > 0 {
+		var s string
+		if err := json.Unmarshal(obj.Value, &s); err == nil {
+			return s
+		}
+		return string(obj.Value)
+	}
+	if obj.Description != "" {
+		return obj.Description
+	}
+	return string(obj.Type)
+}
+
+// traceAction captures the state of the tab after an action, if tracing was
+// requested.
+func (r *Request) traceAction(ctx context.Context, actionPos int, name string, start time.Time, err error) {
+	t := r.tracer
+	if t == nil {
+		return
+	}
+	step := &TraceStep{
+		Block:      r.pos,
+		Action:     actionPos,
+		Name:       name,
+		Iteration:  r.iteration,
+		DurationMS: milliseconds(time.Since(start)),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+
+	// the action may have failed because ctx is done
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), traceCaptureTimeout)
+	defer cancel()
+	chromedp.Run(cctx, chromedp.Location(&step.URL))
+	p := page.CaptureScreenshot().
+		WithFormat(page.CaptureScreenshotFormatJpeg).
+		WithQuality(traceScreenshotQuality)
+	if _, _, _, _, visual, _, err := page.GetLayoutMetrics().Do(cctx); err == nil && visual.ClientWidth > traceScreenshotWidth {
+		p = p.WithClip(&page.Viewport{
+			X: visual.PageX, Y: visual.PageY,
+			Width: visual.ClientWidth, Height: visual.ClientHeight,
+			Scale: traceScreenshotWidth / visual.ClientWidth,
+		})
+	}
+	step.Screenshot, _ = p.Do(cctx)
+
+	t.mu.Lock()
+	step.Console = append([]string{}, t.console...)
+	t.console = t.console[:0]
+	t.add(step)
+	t.mu.Unlock()
+}
@@ -0,0 +1,19 @@
+package decap
+
+import "testing"
+
+func TestTraceKeepsLastSteps(t *testing.T) {
+	tr := &tracer{maxSteps: 10}
+	for i := range tr.maxSteps + 5 {
+		tr.add(&TraceStep{Action: i})
+	}
+	trace := tr.result()
+	if len(trace.Steps) != tr.maxSteps || trace.Dropped != 5 {
+		t.Fatalf("got %d steps and %d dropped", len(trace.Steps), trace.Dropped)
+	}
+	for i, step := range trace.Steps {
+		if step.Action != i+5 {
+			t.Fatalf("step %d is action %d, want %d", i, step.Action, i+5)
+		}
+	}
+}
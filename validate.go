@@ -0,0 +1,133 @@
+package decap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ValidationError is a problem found by Validate, located by the JSON path
+// of the offending field (e.g. "query[1].actions[0]").
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Plan is the normalized form of a valid request, showing how it would be
+// executed with all defaults filled in.
+type Plan struct {
+	Timeout         string           `json:"timeout"`
+	RenderDelay     string           `json:"global_render_delay"`
+	ResponseFormat  string           `json:"response_format,omitempty"`
+	SessionID       string           `json:"sessionid,omitempty"`
+	LoadTab         string           `json:"load_tab,omitempty"`
+	ReuseTab        bool             `json:"reuse_tab"`
+	ReuseWindow     bool             `json:"reuse_window"`
+	CallbackURL     string           `json:"callback_url,omitempty"`
+	EmulateViewport *ViewportBlock   `json:"emulate_viewport,omitempty"`
+	NetworkIdle     NetworkIdleBlock `json:"network_idle"`
+	Query           []PlanBlock      `json:"query"`
+}
+
+type PlanBlock struct {
+	Actions []PlanAction `json:"actions"`
+	Repeat  int          `json:"repeat"`
+	While   *Action      `json:"while,omitempty"`
+}
+
+// PlanAction is an action of a plan. Selector holds the expanded selector
+// of actions such as remove_info_boxes which target a built-in list of
+// elements.
+type PlanAction struct {
+	Action   Action `json:"action"`
+	Selector string `json:"selector,omitempty"`
+}
+
+// Validate parses the request like ParseRequest without launching a
+// browser, but collects every problem instead of stopping at the first. If
+// there are none, it returns the plan of the request.
+func (r *Request) Validate(body io.Reader) (*Plan, []*ValidationError) {
+	err := json.NewDecoder(body).Decode(&r)
+	if err != nil {
+		return nil, []*ValidationError{{Message: fmt.Sprintf("JSON parsing error: %s", err)}}
+	}
+
+	var problems []*ValidationError
+	report := func(path string, err error) bool {
+		// most errors are prefixed by the path of their field, which may
+		// be more specific (e.g. "emulate_viewport.width")
+		msg := err.Error()
+		if p, rest, ok := strings.Cut(msg, ": "); ok && (p == path ||
+			strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[")) {
+			path, msg = p, rest
+		}
+		problems = append(problems, &ValidationError{Path: path, Message: msg})
+		return true
+	}
+	if len(r.Query) == 0 {
+		report("query", fmt.Errorf("must contain at least one action block"))
+	}
+	r.parseSettings(report)
+	if len(r.Query) > 0 {
+		r.parseQueryBlocks(report)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return r.plan(), nil
+}
+
+func (r *Request) plan() *Plan {
+	p := &Plan{
+		Timeout:         r.timeout.String(),
+		RenderDelay:     r.renderDelay.String(),
+		ResponseFormat:  r.ResponseFormat,
+		SessionID:       r.SessionID,
+		LoadTab:         r.oldTabID,
+		ReuseTab:        r.ReuseTab,
+		ReuseWindow:     r.ReuseWindow,
+		CallbackURL:     r.CallbackURL,
+		EmulateViewport: r.EmulateViewport,
+		NetworkIdle: NetworkIdleBlock{
+			MaxInflight: r.networkIdle.maxInflight,
+			Quiet:       r.networkIdle.quiet.String(),
+			Ignore:      []string{},
+		},
+	}
+	for _, re := range r.networkIdle.ignore {
+		p.NetworkIdle.Ignore = append(p.NetworkIdle.Ignore, re.String())
+	}
+	for _, block := range r.Query {
+		pb := PlanBlock{Actions: []PlanAction{}, Repeat: *block.Repeat, While: block.While}
+		for _, xa := range block.Actions {
+			pb.Actions = append(pb.Actions, PlanAction{Action: xa, Selector: macroSelector(xa.Name())})
+		}
+		p.Query = append(p.Query, pb)
+	}
+	return p
+}
+
+// macroSelector returns the built-in selector targeted by the named action,
+// or "" if it takes its selectors as arguments.
+func macroSelector(name string) string {
+	switch name {
+	case "hide_nav_buttons":
+		return navButtonSelector
+	case "remove_info_boxes":
+		return infoBoxSelector
+	case "remove_info_sections":
+		return infoSectionSelector
+	case "remove_nav_sections":
+		return navSectionSelector
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,68 @@
+package decap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateWithoutQuery(t *testing.T) {
+	body := `{"emulate_viewport":{"width":1,"height":1},"global_render_delay":"1s"}`
+	plan, problems := new(Request).Validate(strings.NewReader(body))
+	if plan != nil {
+		t.Fatalf("got a plan for a request without query")
+	}
+	if len(problems) != 1 || problems[0].Path != "query" {
+		t.Fatalf("got problems %v, want one at query", problems)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	body := `{
+		"global_render_delay": "x",
+		"timeout": "y",
+		"emulate_viewport": {"width": 0, "height": 1},
+		"network_idle": {"quiet": "-1s"},
+		"response_format": "gif",
+		"query": [{"actions": [["navigate", "http://example.com"], ["frobnicate"]]}]
+	}`
+	_, problems := new(Request).Validate(strings.NewReader(body))
+	got := make(map[string]string)
+	for _, p := range problems {
+		got[p.Path] = p.Message
+	}
+	for _, path := range []string{
+		"global_render_delay",
+		"timeout",
+		"emulate_viewport.width",
+		"network_idle.quiet",
+		"response_format",
+		"query[0].actions[1]",
+	} {
+		msg, ok := got[path]
+		if !ok {
+			t.Errorf("no problem reported at %s, got %v", path, got)
+			continue
+		}
+		// the path is given separately and shouldn't be repeated
+		if strings.Contains(msg, path) {
+			t.Errorf("%s: message %q repeats the path", path, msg)
+		}
+	}
+}
+
+func TestValidatePlan(t *testing.T) {
+	body := `{"global_render_delay": "1s", "query": [{"actions": [["navigate", "http://example.com"], ["remove_info_boxes"]]}]}`
+	plan, problems := new(Request).Validate(strings.NewReader(body))
+	if len(problems) > 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+	if plan.Timeout != "20s" || plan.RenderDelay != "1s" {
+		t.Errorf("got timeout %s and render delay %s", plan.Timeout, plan.RenderDelay)
+	}
+	if len(plan.Query) != 1 || len(plan.Query[0].Actions) != 2 {
+		t.Fatalf("got plan query %+v", plan.Query)
+	}
+	if plan.Query[0].Actions[1].Selector != infoBoxSelector {
+		t.Errorf("remove_info_boxes selector not expanded")
+	}
+}
@@ -0,0 +1,165 @@
+package decap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	DefaultWaitTimeout  = 10 * time.Second
+	DefaultWaitInterval = 100 * time.Millisecond
+)
+
+// domQuietCmd installs a MutationObserver on first use and returns the number
+// of milliseconds since the DOM was last mutated.
+const domQuietCmd = `(() => {
+	if (window.__decapLastMutation === undefined) {
+		window.__decapLastMutation = Date.now();
+		new MutationObserver(() => { window.__decapLastMutation = Date.now(); })
+			.observe(document, {subtree: true, childList: true, attributes: true, characterData: true});
+	}
+	return Date.now() - window.__decapLastMutation;
+})()`
+
+// waitCondition blocks until the condition holds or ctx is done.
+type waitCondition func(ctx context.Context) error
+
+// waitFor runs cond with its own timeout and reports a descriptive error if
+// the timeout (rather than the overall tab timeout) ran out.
+func waitFor(desc string, cond waitCondition, timeout time.Duration) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		wctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err := cond(wctx)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return fmt.Errorf("wait_for %s: timed out after %s", desc, timeout)
+		}
+		if err != nil {
+			return fmt.Errorf("wait_for %s: %s", desc, err)
+		}
+		return nil
+	}
+}
+
+// pollUntil builds a waitCondition which calls check every interval until it
+// returns true.
+func pollUntil(interval time.Duration, check func(ctx context.Context) (bool, error)) waitCondition {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ok, err := check(ctx)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				return err
+			}
+			if ok {
+				return nil
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func evalBool(cmd string) func(ctx context.Context) (bool, error) {
+	return func(ctx context.Context) (bool, error) {
+		var res bool
+		err := chromedp.Evaluate(cmd, &res).Do(ctx)
+		return res, err
+	}
+}
+
+func waitSelectorExists(sel string, interval time.Duration) waitCondition {
+	cmd := fmt.Sprintf("document.querySelector(%s) !== null", jsString(sel))
+	return pollUntil(interval, evalBool(cmd))
+}
+
+func waitSelectorGone(sel string, interval time.Duration) waitCondition {
+	cmd := fmt.Sprintf("document.querySelector(%s) === null", jsString(sel))
+	return pollUntil(interval, evalBool(cmd))
+}
+
+func waitSelectorVisible(sel string, interval time.Duration) waitCondition {
+	findElem := fmt.Sprintf("var e = document.querySelector(%s)", jsString(sel))
+	isVisible := "!!(e.offsetWidth || e.offsetHeight || e.getClientRects().length)"
+	cmd := fmt.Sprintf("(() => { %s; return e ? %s : false; })()", findElem, isVisible)
+	return pollUntil(interval, evalBool(cmd))
+}
+
+func waitJSTruthy(expr string, interval time.Duration) waitCondition {
+	return pollUntil(interval, evalBool(fmt.Sprintf("!!(%s)", expr)))
+}
+
+func waitURLMatches(re *regexp.Regexp, interval time.Duration) waitCondition {
+	return pollUntil(interval, func(ctx context.Context) (bool, error) {
+		var loc string
+		err := chromedp.Location(&loc).Do(ctx)
+		return re.MatchString(loc), err
+	})
+}
+
+func waitDOMStable(quiet, interval time.Duration) waitCondition {
+	return pollUntil(interval, func(ctx context.Context) (bool, error) {
+		var ms float64
+		err := chromedp.Evaluate(domQuietCmd, &ms).Do(ctx)
+		return time.Duration(ms)*time.Millisecond >= quiet, err
+	})
+}
+
+// waitRequestFinished waits until a request with a URL matching re has
+// finished loading. Only requests sent after the wait began are considered.
+func waitRequestFinished(re *regexp.Regexp) waitCondition {
+	return func(ctx context.Context) error {
+		var mu sync.Mutex
+		matching := make(map[network.RequestID]bool)
+		done := make(chan struct{})
+
+		lctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		chromedp.ListenTarget(lctx, func(ev interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch e := ev.(type) {
+			case *network.EventRequestWillBeSent:
+				if re.MatchString(e.Request.URL) {
+					matching[e.RequestID] = true
+				}
+			case *network.EventLoadingFinished:
+				if matching[e.RequestID] {
+					delete(matching, e.RequestID)
+					select {
+					case <-done:
+					default:
+						close(done)
+					}
+				}
+			}
+		})
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}